@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// vendetta add <import path>[@<revision>]
+//
+// Add a submodule for a package even though the root project doesn't
+// import it yet, optionally checking out a particular commit, tag or
+// branch.
+func (v *vendetta) addCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Usage: vendetta add <import path>[@<revision>]")
+	}
+
+	pkg, rev := args[0], ""
+	if at := strings.LastIndexByte(pkg, '@'); at >= 0 {
+		pkg, rev = pkg[:at], pkg[at+1:]
+	}
+
+	found, pkgdir, err := v.searchGoPath("", pkg)
+	if err != nil {
+		return err
+	}
+
+	if found {
+		if rev == "" {
+			fmt.Fprintf(os.Stderr, "Package %s is already present in %s\n", pkg, pkgdir)
+		}
+	} else {
+		pkgdir, err = v.obtainPackage(pkg)
+		if err != nil {
+			return err
+		}
+
+		if pkgdir == "" {
+			return fmt.Errorf("%s is a standard package", pkg)
+		}
+	}
+
+	sm := v.pathInSubmodule(pkgdir)
+	if sm == nil || !isSubpath(sm.dir, "vendor") {
+		return fmt.Errorf("Package %s is not provided by a submodule under vendor/ (found in %s)", pkg, pkgdir)
+	}
+
+	if rev != "" {
+		if found {
+			if err := v.checkUnmodified(sm.dir, "checking out a revision in"); err != nil {
+				return err
+			}
+
+			if err := v.journalSubmoduleState(sm.dir); err != nil {
+				return err
+			}
+		}
+
+		if err := v.checkoutRevision(sm, rev); err != nil {
+			return err
+		}
+	}
+
+	// Now that the right revision is checked out, make sure the
+	// package's own dependencies are present.
+	return v.resolveDependency("", pkg)
+}
+
+// Check out a commit, tag or branch in a submodule.  For a branch,
+// the submodule is also set to track it.
+func (v *vendetta) checkoutRevision(sm *submodule, rev string) error {
+	refs, err := v.popen("git", "-C", sm.dir, "ls-remote", "origin",
+		"refs/heads/"+rev, "refs/tags/"+rev)
+	if err != nil {
+		return err
+	}
+
+	defer refs.close()
+
+	isBranch, isTag := false, false
+	for refs.Scan() {
+		fields := splitWS(refs.Text())
+		if len(fields) < 2 {
+			return fmt.Errorf("could not parse 'git ls-remote' output")
+		}
+
+		switch fields[1] {
+		case "refs/heads/" + rev:
+			isBranch = true
+		case "refs/tags/" + rev:
+			isTag = true
+		}
+	}
+
+	if err := refs.close(); err != nil {
+		return err
+	}
+
+	switch {
+	case isBranch:
+		if sm.branch != rev {
+			fmt.Fprintf(os.Stderr, "Tracking branch %s in submodule %s\n", rev, sm.dir)
+			if err := v.setSubmoduleConfig(sm, "branch", rev); err != nil {
+				return err
+			}
+
+			sm.branch = rev
+		}
+
+		args := []string{"-C", sm.dir, "fetch", "-q"}
+		shallow, err := v.isShallow(sm.dir)
+		if err != nil {
+			return err
+		}
+
+		if shallow {
+			args = append(args, "--depth", "1")
+		}
+
+		ref := "refs/remotes/origin/" + rev
+		if err := v.git(append(args, "origin", "+refs/heads/"+rev+":"+ref)...); err != nil {
+			return err
+		}
+
+		return v.checkoutRef(sm.dir, ref, "branch "+rev)
+
+	case isTag:
+		return v.checkoutTag(sm.dir, rev)
+	}
+
+	// Otherwise it should be a commit.  It might not be within
+	// the history of a shallow submodule, or might not have been
+	// fetched yet.
+	ref := rev + "^{commit}"
+	if !v.gitSucceeds("-C", sm.dir, "rev-parse", "-q", "--verify", ref) {
+		shallow, err := v.isShallow(sm.dir)
+		if err != nil {
+			return err
+		}
+
+		if shallow {
+			err = v.deepenSubmodule(sm.dir)
+		} else if !v.gitSucceeds("-C", sm.dir, "fetch", "-q", "origin", rev) {
+			// Servers might not allow fetching a commit
+			// by its id, and an abbreviated id can't be
+			// fetched, so fetch all the branches instead
+			err = v.git("-C", sm.dir, "fetch", "-q", "origin")
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if !v.gitSucceeds("-C", sm.dir, "rev-parse", "-q", "--verify", ref) {
+			return fmt.Errorf("No branch, tag or commit '%s' found in submodule %s", rev, sm.dir)
+		}
+	}
+
+	return v.checkoutRef(sm.dir, ref, "commit "+rev)
+}
+
+func (v *vendetta) checkoutRef(dir, ref, desc string) error {
+	commit, err := v.gitOutput("-C", dir, "rev-parse", ref)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Checking out %s in submodule %s\n", desc, dir)
+	return v.checkoutCommit(dir, commit)
+}
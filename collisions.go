@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Import paths that differ only in case, such as Sirupsen/logrus and
+// sirupsen/logrus, map to directories that collide on
+// case-insensitive filesystems, even though all is well on Linux.
+
+// Record an import of the package in a directory.
+func (v *vendetta) recordImporter(pkgdir string, imp importer) {
+	for _, other := range v.importers[pkgdir] {
+		if other == imp {
+			return
+		}
+	}
+
+	v.importers[pkgdir] = append(v.importers[pkgdir], imp)
+}
+
+// Check the package directories, whether imported, scanned or
+// holding submodules, for paths that differ only in case.
+func (v *vendetta) checkCaseCollisions() error {
+	dirSet := make(map[string]bool)
+	for dir := range v.importers {
+		dirSet[dir] = true
+	}
+
+	for dir := range v.dirPackages {
+		dirSet[dir] = true
+	}
+
+	for _, sm := range v.submodules {
+		if !v.pruned(&sm) {
+			dirSet[sm.dir] = true
+		}
+	}
+
+	var dirs []string
+	for dir := range dirSet {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	sort.Strings(dirs)
+
+	// Directories collide if any of their ancestors do, so gather
+	// the spellings of each ancestor, and the directories under
+	// each spelling.
+	spellings := make(map[string][]string)
+	under := make(map[string][]string)
+	for _, dir := range dirs {
+		parts := strings.Split(filepath.ToSlash(dir), "/")
+		for i := range parts {
+			prefix := strings.Join(parts[:i+1], "/")
+			lower := strings.ToLower(prefix)
+			if !containsString(spellings[lower], prefix) {
+				spellings[lower] = append(spellings[lower], prefix)
+			}
+
+			under[prefix] = append(under[prefix], dir)
+		}
+	}
+
+	var lowers []string
+	for lower, s := range spellings {
+		if len(s) > 1 {
+			lowers = append(lowers, lower)
+		}
+	}
+
+	sort.Strings(lowers)
+
+	var collisions []string
+	for _, lower := range lowers {
+		// Only report the collision nearest the root
+		if slash := strings.LastIndexByte(lower, '/'); slash >= 0 && len(spellings[lower[:slash]]) > 1 {
+			continue
+		}
+
+		lines := []string{"Paths differing only in case collide on case-insensitive filesystems:"}
+		for _, prefix := range spellings[lower] {
+			for _, dir := range under[prefix] {
+				lines = append(lines, v.describeImports(dir))
+			}
+		}
+
+		collisions = append(collisions, strings.Join(lines, "\n\t"))
+	}
+
+	if v.check {
+		for _, c := range collisions {
+			v.problem("%s", c)
+		}
+
+		return nil
+	}
+
+	if len(collisions) > 0 {
+		return fmt.Errorf("%s", strings.Join(collisions, "\n"))
+	}
+
+	return nil
+}
+
+// Whether a submodule has been removed by pruning.
+func (v *vendetta) pruned(sm *submodule) bool {
+	return v.prune && !sm.used && isSubpath(sm.dir, "vendor") &&
+		!v.depConfigFor(sm.dir).hold
+}
+
+// Describe a package directory and everything that imports it.
+func (v *vendetta) describeImports(dir string) string {
+	desc := dir
+	if pkg, ok := vendorImportPath(dir); ok {
+		desc = fmt.Sprintf("%s (%s)", pkg, dir)
+	}
+
+	var from []string
+	for _, imp := range v.importers[dir] {
+		from = append(from, v.realDir(imp.dir))
+	}
+
+	if len(from) == 0 {
+		return desc + ", not imported"
+	}
+
+	return desc + ", imported from " + strings.Join(from, ", ")
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+
+	return false
+}
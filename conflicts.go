@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// vendetta conflicts
+//
+// Dependencies often record the revisions of their own dependencies,
+// either as submodules in their vendor directories, or in the
+// manifest of some other vendoring tool.  Report where those
+// revisions disagree with the commits of the top-level submodules,
+// and how the commits are related, so that it is clear whether
+// moving a submodule to the expected commit is an upgrade or a
+// downgrade.
+func (v *vendetta) conflictsCommand(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("Usage: vendetta conflicts")
+	}
+
+	// Expectations, keyed by the directory of the top-level
+	// submodule they apply to
+	expected := make(map[string][]expectation)
+	for i := range v.submodules {
+		sm := &v.submodules[i]
+		if !isSubpath(sm.dir, "vendor") {
+			continue
+		}
+
+		exps, err := v.dependencyExpectations(sm)
+		if err != nil {
+			return err
+		}
+
+		seen := make(map[expectation]bool)
+		for _, e := range exps {
+			target := v.pathInSubmodule(filepath.Join("vendor", packageToPath(e.pkg)))
+			if target == nil || target == sm {
+				continue
+			}
+
+			// Manifests list packages rather than repos, so
+			// there can be several entries for one repo
+			e.pkg = ""
+			if seen[e] {
+				continue
+			}
+
+			seen[e] = true
+			expected[target.dir] = append(expected[target.dir], e)
+		}
+	}
+
+	conflicts := 0
+	for i := range v.submodules {
+		sm := &v.submodules[i]
+		exps := expected[sm.dir]
+		if len(exps) == 0 {
+			continue
+		}
+
+		head, err := v.gitOutput("-C", sm.dir, "rev-parse", "HEAD")
+		if err != nil {
+			return err
+		}
+
+		var lines []string
+		for _, e := range exps {
+			line, err := v.describeExpectation(sm, head, e)
+			if err != nil {
+				return err
+			}
+
+			if line != "" {
+				lines = append(lines, line)
+			}
+		}
+
+		if len(lines) == 0 {
+			continue
+		}
+
+		desc, err := v.describeCommit(sm.dir, head)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s is at %s\n", sm.dir, desc)
+		for _, line := range lines {
+			fmt.Printf("\t%s\n", line)
+		}
+
+		conflicts++
+	}
+
+	if conflicts == 0 {
+		fmt.Fprintln(os.Stderr, "No version conflicts found")
+	}
+
+	return nil
+}
+
+// An expectation is a revision of a package that a dependency was
+// built against.
+type expectation struct {
+	// The dependency submodule directory
+	dep string
+
+	// Where the expectation came from: a nested submodule or a
+	// manifest file
+	source string
+
+	pkg string
+	rev string
+}
+
+// Gather the expectations of a dependency submodule.
+func (v *vendetta) dependencyExpectations(sm *submodule) ([]expectation, error) {
+	var exps []expectation
+
+	links, err := v.gitlinks(sm.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, link := range links {
+		if !isNestedVendored(filepath.Join(sm.dir, link.path)) {
+			continue
+		}
+
+		exps = append(exps, expectation{
+			dep:    sm.dir,
+			source: filepath.Join(sm.dir, link.path),
+			pkg:    pathToPackage(nestedVendorPath(link.path)),
+			rev:    link.commit,
+		})
+	}
+
+	for _, m := range manifestReaders {
+		file := filepath.Join(sm.dir, m.file)
+		data, err := ioutil.ReadFile(v.realDir(file))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		revs, err := m.read(data)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse %s: %s", file, err)
+		}
+
+		for _, r := range revs {
+			exps = append(exps, expectation{
+				dep:    sm.dir,
+				source: file,
+				pkg:    r.pkg,
+				rev:    r.rev,
+			})
+		}
+	}
+
+	return exps, nil
+}
+
+// Produce a line describing an expectation that disagrees with the
+// submodule's commit, or an empty string if it agrees.
+func (v *vendetta) describeExpectation(sm *submodule, head string, e expectation) (string, error) {
+	prefix := fmt.Sprintf("%s expects %s (from %s)", e.dep, e.rev, e.source)
+
+	commit, err := v.gitOutput("-C", sm.dir, "rev-parse", "-q", "--verify",
+		e.rev+"^{commit}")
+	if err != nil {
+		shallow, err := v.isShallow(sm.dir)
+		if err != nil {
+			return "", err
+		}
+
+		if shallow {
+			return prefix + ", which is not in the history of the shallow submodule", nil
+		}
+
+		return prefix + ", which is not in the submodule's repo", nil
+	}
+
+	if commit == head {
+		return "", nil
+	}
+
+	desc, err := v.describeCommit(sm.dir, commit)
+	if err != nil {
+		return "", err
+	}
+
+	prefix = fmt.Sprintf("%s expects %s (from %s)", e.dep, desc, e.source)
+	switch {
+	case v.gitSucceeds("-C", sm.dir, "merge-base", "--is-ancestor", commit, head):
+		return prefix + ", an ancestor of the vendored commit (which is newer)", nil
+	case v.gitSucceeds("-C", sm.dir, "merge-base", "--is-ancestor", head, commit):
+		return prefix + ", a descendant of the vendored commit (which is older)", nil
+	default:
+		return prefix + ", which is unrelated to the vendored commit", nil
+	}
+}
+
+// Describe a commit by its abbreviated id and nearest tag.
+func (v *vendetta) describeCommit(dir, commit string) (string, error) {
+	short, err := v.gitOutput("-C", dir, "rev-parse", "--short", commit)
+	if err != nil {
+		return "", err
+	}
+
+	desc, err := v.gitOutput("-C", dir, "describe", "--tags", "--always", commit)
+	if err != nil || desc == short {
+		return short, nil
+	}
+
+	return fmt.Sprintf("%s (%s)", short, desc), nil
+}
+
+type manifestRev struct {
+	pkg, rev string
+}
+
+// The manifest files of other vendoring tools that record revisions,
+// and how to read them.
+var manifestReaders = []struct {
+	file string
+	read func([]byte) ([]manifestRev, error)
+}{
+	{"Godeps/Godeps.json", readGodeps},
+	{"glide.lock", readGlideLock},
+	{"Gopkg.lock", readGopkgLock},
+	{"vendor/vendor.json", readGovendor},
+	{"vendor/manifest", readGvt},
+}
+
+func readGodeps(data []byte) ([]manifestRev, error) {
+	var m struct {
+		Deps []struct {
+			ImportPath string
+			Rev        string
+		}
+	}
+
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	var revs []manifestRev
+	for _, d := range m.Deps {
+		revs = append(revs, manifestRev{d.ImportPath, d.Rev})
+	}
+
+	return dropMissingRevs(revs), nil
+}
+
+func readGovendor(data []byte) ([]manifestRev, error) {
+	var m struct {
+		Package []struct {
+			Path     string `json:"path"`
+			Revision string `json:"revision"`
+		} `json:"package"`
+	}
+
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	var revs []manifestRev
+	for _, p := range m.Package {
+		revs = append(revs, manifestRev{p.Path, p.Revision})
+	}
+
+	return dropMissingRevs(revs), nil
+}
+
+func readGvt(data []byte) ([]manifestRev, error) {
+	var m struct {
+		Dependencies []struct {
+			ImportPath string `json:"importpath"`
+			Revision   string `json:"revision"`
+		} `json:"dependencies"`
+	}
+
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	var revs []manifestRev
+	for _, d := range m.Dependencies {
+		revs = append(revs, manifestRev{d.ImportPath, d.Revision})
+	}
+
+	return dropMissingRevs(revs), nil
+}
+
+// glide.lock is YAML, but only a simple subset of it is needed:
+//
+//	imports:
+//	- name: github.com/user/lib
+//	  version: <commit>
+func readGlideLock(data []byte) ([]manifestRev, error) {
+	var revs []manifestRev
+	var cur *manifestRev
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "- name:"):
+			revs = append(revs, manifestRev{
+				pkg: unquote(strings.TrimSpace(line[len("- name:"):])),
+			})
+			cur = &revs[len(revs)-1]
+		case strings.HasPrefix(line, "version:") && cur != nil:
+			cur.rev = unquote(strings.TrimSpace(line[len("version:"):]))
+		}
+	}
+
+	return dropMissingRevs(revs), scanner.Err()
+}
+
+// Gopkg.lock is TOML, but again only a simple subset is needed:
+//
+//	[[projects]]
+//	  name = "github.com/user/lib"
+//	  revision = "<commit>"
+func readGopkgLock(data []byte) ([]manifestRev, error) {
+	var revs []manifestRev
+	var cur *manifestRev
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "[[projects]]" {
+			revs = append(revs, manifestRev{})
+			cur = &revs[len(revs)-1]
+			continue
+		}
+
+		// Other tables, such as [solve-meta], end the project
+		if strings.HasPrefix(line, "[") {
+			cur = nil
+			continue
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 || cur == nil {
+			continue
+		}
+
+		value := unquote(strings.TrimSpace(line[eq+1:]))
+		switch strings.TrimSpace(line[:eq]) {
+		case "name":
+			cur.pkg = value
+		case "revision":
+			cur.rev = value
+		}
+	}
+
+	return dropMissingRevs(revs), scanner.Err()
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+
+	return s
+}
+
+func dropMissingRevs(revs []manifestRev) []manifestRev {
+	res := revs[:0]
+	for _, r := range revs {
+		if r.pkg != "" && r.rev != "" {
+			res = append(res, r)
+		}
+	}
+
+	return res
+}
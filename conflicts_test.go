@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReadGlideLock(t *testing.T) {
+	tests := []struct {
+		data string
+		revs []manifestRev
+	}{
+		{`hash: 0123456789abcdef
+updated: 2017-01-01T00:00:00Z
+imports:
+- name: github.com/a/lib
+  version: 1111111111111111111111111111111111111111
+  subpackages:
+  - sub
+- name: "github.com/b/util"
+  version: '2222222222222222222222222222222222222222'
+- name: github.com/no/version
+testImports:
+- name: github.com/c/testdep
+  version: 3333333333333333333333333333333333333333
+`, []manifestRev{
+			{"github.com/a/lib", "1111111111111111111111111111111111111111"},
+			{"github.com/b/util", "2222222222222222222222222222222222222222"},
+			{"github.com/c/testdep", "3333333333333333333333333333333333333333"},
+		}},
+		{"hash: x\nimports: []\ntestImports: []\n", nil},
+	}
+
+	for _, test := range tests {
+		revs, err := readGlideLock([]byte(test.data))
+		if err != nil {
+			t.Errorf("reading %q: %s", test.data, err)
+		} else if !(len(revs) == 0 && len(test.revs) == 0) && !reflect.DeepEqual(revs, test.revs) {
+			t.Errorf("reading %q gave %v; expected %v", test.data, revs, test.revs)
+		}
+	}
+}
+
+func TestReadGopkgLock(t *testing.T) {
+	tests := []struct {
+		data string
+		revs []manifestRev
+	}{
+		{`# This file is autogenerated, do not edit; changes may be undone by the next 'dep ensure'.
+
+
+[[projects]]
+  name = "github.com/a/lib"
+  packages = [
+    ".",
+    "sub"
+  ]
+  revision = "1111111111111111111111111111111111111111"
+  version = "v1.0.0"
+
+[[projects]]
+  branch = "master"
+  name = "github.com/b/util"
+  revision = "2222222222222222222222222222222222222222"
+
+[[projects]]
+  name = "github.com/no/revision"
+
+[solve-meta]
+  analyzer-name = "dep"
+  analyzer-version = 1
+  inputs-digest = "abcdef"
+  name = "not/a/project"
+  revision = "4444444444444444444444444444444444444444"
+  solver-name = "gps-cdcl"
+  solver-version = 1
+`, []manifestRev{
+			{"github.com/a/lib", "1111111111111111111111111111111111111111"},
+			{"github.com/b/util", "2222222222222222222222222222222222222222"},
+		}},
+	}
+
+	for _, test := range tests {
+		revs, err := readGopkgLock([]byte(test.data))
+		if err != nil {
+			t.Errorf("reading %q: %s", test.data, err)
+		} else if !reflect.DeepEqual(revs, test.revs) {
+			t.Errorf("reading %q gave %v; expected %v", test.data, revs, test.revs)
+		}
+	}
+}
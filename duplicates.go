@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The same repo can end up vendored under two import paths, e.g. a
+// vanity path and a github path, or the paths before and after a
+// user or org was renamed.  The copies compile into distinct
+// packages, which causes subtle bugs with anything registered in
+// global state (flags, metrics, sql drivers).  Submodules whose URLs
+// match hold the same repo.  Submodules that merely share a root
+// commit might be the same repo, but might also be a fork alongside
+// its upstream, or major versions served from one repo (such as
+// gopkg.in/yaml.v2 and yaml.v3), so they only get a warning.
+
+// Check the used submodules for repos vendored more than once.
+func (v *vendetta) checkDuplicateRepos() error {
+	var sms []*submodule
+	for i := range v.submodules {
+		sm := &v.submodules[i]
+		if _, ok := vendorImportPath(sm.dir); ok && sm.used {
+			sms = append(sms, sm)
+		}
+	}
+
+	byURL := make(map[string][]*submodule)
+	byRoot := make(map[string][]*submodule)
+	for _, sm := range sms {
+		if sm.url != "" {
+			// Hosts generally treat repo paths case
+			// insensitively
+			url := strings.ToLower(sm.url)
+			if u, ok := parseRepoURL(sm.url); ok {
+				url = strings.ToLower(u.host + ":" + u.port + "/" + u.path)
+			}
+
+			byURL[url] = append(byURL[url], sm)
+		}
+
+		roots, err := v.rootCommits(sm)
+		if err != nil {
+			return err
+		}
+
+		for _, root := range roots {
+			byRoot[root] = append(byRoot[root], sm)
+		}
+	}
+
+	var dups []string
+	sameURL := make(map[[2]*submodule]bool)
+	for _, g := range byURL {
+		if len(g) < 2 {
+			continue
+		}
+
+		for _, a := range g {
+			for _, b := range g {
+				sameURL[[2]*submodule{a, b}] = true
+			}
+		}
+
+		pkgs := submodulePackages(g)
+		dups = append(dups, fmt.Sprintf("The same repo is vendored as %s; import it only as %s",
+			strings.Join(pkgs, " and "), v.preferredRepoPath(g, pkgs)))
+	}
+
+	// Warn about each pair sharing a root commit only once
+	var warnings []string
+	warned := make(map[[2]*submodule]bool)
+	for root, g := range byRoot {
+		for i, a := range g {
+			for _, b := range g[i+1:] {
+				pair := [2]*submodule{a, b}
+				if sameURL[pair] || warned[pair] {
+					continue
+				}
+
+				warned[pair] = true
+				pkgs := submodulePackages([]*submodule{a, b})
+				warnings = append(warnings, fmt.Sprintf("Warning: %s and %s share the root commit %s, so they might be the same repo vendored twice",
+					pkgs[0], pkgs[1], root))
+			}
+		}
+	}
+
+	sort.Strings(warnings)
+	for _, w := range warnings {
+		fmt.Println(w)
+	}
+
+	sort.Strings(dups)
+	if v.check {
+		for _, dup := range dups {
+			v.problem("%s", dup)
+		}
+
+		return nil
+	}
+
+	if len(dups) > 0 {
+		return fmt.Errorf("%s", strings.Join(dups, "\n"))
+	}
+
+	return nil
+}
+
+func submodulePackages(sms []*submodule) []string {
+	var pkgs []string
+	for _, sm := range sms {
+		pkg, _ := vendorImportPath(sm.dir)
+		pkgs = append(pkgs, pkg)
+	}
+
+	sort.Strings(pkgs)
+	return pkgs
+}
+
+// The root commits of the repo in a submodule.  In a shallow repo,
+// the commits at the shallow boundary look like roots, so nothing is
+// returned.
+func (v *vendetta) rootCommits(sm *submodule) ([]string, error) {
+	if sm.marker == '-' {
+		return nil, nil
+	}
+
+	shallow, err := v.isShallow(sm.dir)
+	if err != nil || shallow {
+		return nil, err
+	}
+
+	out, err := v.gitOutput("-C", sm.dir, "rev-list", "--max-parents=0", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	return splitWS(out), nil
+}
+
+// Suggest which of the import paths of a repo vendored more than
+// once the project should use.
+func (v *vendetta) preferredRepoPath(sms []*submodule, pkgs []string) string {
+	// An import comment on the package at the top of the repo
+	// gives its canonical path
+	for _, sm := range sms {
+		ic := v.importComment(sm.dir)
+		for _, pkg := range pkgs {
+			if ic == pkg {
+				return pkg
+			}
+		}
+	}
+
+	// Otherwise, prefer the path that matches the URL of the repo
+	for _, sm := range sms {
+		if host, path, ok := splitRepoURL(sm.url); ok {
+			for _, pkg := range pkgs {
+				if pkg == host+"/"+path {
+					return pkg
+				}
+			}
+		}
+	}
+
+	return pkgs[0]
+}
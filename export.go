@@ -0,0 +1,441 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// vendetta export <file.tar[.gz]|directory>
+//
+// Produce a copy of the project, as committed in HEAD, with the files
+// of each submodule inlined at the commit recorded for it, so that
+// the result can be built without git.  With -p, unused submodules
+// and the unused packages within dependency submodules are omitted.
+func (v *vendetta) exportCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Usage: vendetta [-p] export <file.tar|file.tar.gz|directory>")
+	}
+
+	if v.prune {
+		v.readOnly = true
+		if err := v.resolveRootProjectDeps(v.rootPkgs); err != nil {
+			return err
+		}
+	}
+
+	w, err := newExportWriter(args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Exporting to %s\n", args[0])
+	err = v.exportRepo(w, "", "HEAD")
+	if err2 := w.close(); err == nil {
+		err = err2
+	}
+
+	return err
+}
+
+// Export the files of a commit in a repo (the project itself, or a
+// submodule), followed by those of its submodules.
+func (v *vendetta) exportRepo(w exportWriter, dir, commit string) error {
+	links, err := v.treeGitlinks(dir, commit)
+	if err != nil {
+		return err
+	}
+
+	isLink := make(map[string]bool)
+	for _, link := range links {
+		isLink[link.path] = true
+	}
+
+	var goDirs map[string]bool
+	if v.prune {
+		files, err := v.treeFiles(dir, commit)
+		if err != nil {
+			return err
+		}
+
+		goDirs = goPackageDirs(files)
+	}
+
+	if err := v.readArchive(dir, commit, func(hdr *tar.Header, r io.Reader) error {
+		name := strings.TrimSuffix(hdr.Name, "/")
+		if name == ".gitmodules" || isLink[name] {
+			return nil
+		}
+
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if !v.exportIncludes(dir, file, hdr, goDirs) {
+			return nil
+		}
+
+		hdr.Name = filepath.ToSlash(file)
+		if hdr.Typeflag == tar.TypeDir {
+			hdr.Name += "/"
+		}
+
+		return w.add(hdr, r)
+	}); err != nil {
+		return err
+	}
+
+	for _, link := range links {
+		sub := filepath.Join(dir, filepath.FromSlash(link.path))
+		if sm := v.pathInSubmodule(sub); v.prune && sm != nil &&
+			sm.dir == sub && !sm.used && isSubpath(sub, "vendor") &&
+			!v.depConfigFor(sub).hold {
+			fmt.Fprintf(os.Stderr, "Omitting unused submodule %s\n", sub)
+			continue
+		}
+
+		if !v.gitSucceeds("-C", sub, "cat-file", "-e", link.commit+"^{commit}") {
+			return fmt.Errorf("Commit %s of submodule %s is not available; maybe you need to run 'git submodule update --init --recursive'?", link.commit, sub)
+		}
+
+		if err := v.exportRepo(w, sub, link.commit); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// With -p, only the used packages of dependency submodules are
+// exported, along with the subdirectories they need and their
+// license files.  goDirs holds the directories of the repo that
+// contain go files.
+func (v *vendetta) exportIncludes(repo, file string, hdr *tar.Header, goDirs map[string]bool) bool {
+	if !v.prune {
+		return true
+	}
+
+	// Directories are implied by the files in them, and leaving
+	// them out avoids empty directories where files are omitted.
+	if hdr.Typeflag == tar.TypeDir {
+		return false
+	}
+
+	sm := v.pathInSubmodule(file)
+	if sm == nil || !isSubpath(sm.dir, "vendor") || v.depConfigFor(sm.dir).hold {
+		return true
+	}
+
+	// Find the package the file belongs to
+	dir := parentDir(file)
+	rel := func(d string) string {
+		return filepath.ToSlash(strings.TrimPrefix(d[len(repo):], string(os.PathSeparator)))
+	}
+
+	for d := dir; isSubpath(d, repo); d = parentDir(d) {
+		if _, used := v.importedBy[d]; used {
+			return isPackageDataDir(rel(dir), rel(d), goDirs)
+		}
+
+		if d == repo {
+			break
+		}
+	}
+
+	return dir == repo && isLicenseFile(filepath.Base(file))
+}
+
+// Packages can need files in subdirectories that are not packages
+// themselves, e.g. for //go:embed, cgo includes or testdata.  Is dir
+// either the package directory pkgdir or such a subdirectory of it?
+// Paths are slash-separated and relative to the top of a repo, and
+// goDirs holds the directories containing go files.
+func isPackageDataDir(dir, pkgdir string, goDirs map[string]bool) bool {
+	if pkgdir != "" && dir != pkgdir && !strings.HasPrefix(dir, pkgdir+"/") {
+		return false
+	}
+
+	for d := dir; d != pkgdir; d = slashParentDir(d) {
+		// Go files in testdata are not packages
+		if goDirs[d] && !strings.Contains("/"+d[len(pkgdir):]+"/", "/testdata/") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Like parentDir, but for slash-separated paths.
+func slashParentDir(p string) string {
+	if slash := strings.LastIndexByte(p, '/'); slash >= 0 {
+		return p[:slash]
+	}
+
+	return ""
+}
+
+// Find the directories holding go files, given the slash-separated
+// paths of the files in a repo.
+func goPackageDirs(files []string) map[string]bool {
+	dirs := make(map[string]bool)
+	for _, f := range files {
+		if strings.HasSuffix(f, ".go") {
+			dirs[slashParentDir(f)] = true
+		}
+	}
+
+	return dirs
+}
+
+// List the files in a commit of a repo.
+func (v *vendetta) treeFiles(dir, commit string) ([]string, error) {
+	out, err := v.popen("git", "-C", repoDir(dir), "ls-tree", "-r", "--name-only", commit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer out.close()
+
+	var files []string
+	for out.Scan() {
+		files = append(files, out.Text())
+	}
+
+	return files, out.close()
+}
+
+// Is a file likely to hold licensing terms that should accompany
+// the code?
+func isLicenseFile(name string) bool {
+	name = strings.ToLower(name)
+	for _, prefix := range []string{"license", "licence", "copying", "notice", "patents", "unlicense"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// List the submodules recorded in a commit.
+func (v *vendetta) treeGitlinks(dir, commit string) ([]gitlink, error) {
+	tree, err := v.popen("git", "-C", repoDir(dir), "ls-tree", "-r", commit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tree.close()
+
+	var links []gitlink
+	for tree.Scan() {
+		// Each line is "<mode> <type> <object>\t<path>"
+		line := tree.Text()
+		tab := strings.IndexByte(line, '\t')
+		if tab < 0 {
+			return nil, fmt.Errorf("could not parse 'git ls-tree' output")
+		}
+
+		fields := splitWS(line[:tab])
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("could not parse 'git ls-tree' output")
+		}
+
+		if fields[0] == "160000" {
+			links = append(links, gitlink{
+				path:   line[tab+1:],
+				commit: fields[2],
+			})
+		}
+	}
+
+	if err := tree.close(); err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+// The argument for "git -C" for a repo directory relative to the
+// project.
+func repoDir(dir string) string {
+	if dir == "" {
+		return "."
+	}
+
+	return dir
+}
+
+// Run 'git archive' on a commit in a repo, calling f for each entry.
+func (v *vendetta) readArchive(dir, commit string, f func(*tar.Header, io.Reader) error) error {
+	args := []string{"-C", repoDir(dir), "archive", "--format=tar", commit}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = v.rootDir
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(stdout)
+	for {
+		var hdr *tar.Header
+		hdr, err = tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+
+			break
+		}
+
+		// Skip the pax header holding the commit id
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+
+		if err = f(hdr, tr); err != nil {
+			break
+		}
+	}
+
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("Command failed: git %s (%s)",
+			strings.Join(args, " "), err)
+	}
+
+	return nil
+}
+
+// An exportWriter receives the exported files, as tar entries with
+// paths relative to the top of the export.
+type exportWriter interface {
+	add(hdr *tar.Header, r io.Reader) error
+	close() error
+}
+
+func newExportWriter(dest string) (exportWriter, error) {
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar"} {
+		if !strings.HasSuffix(dest, ext) {
+			continue
+		}
+
+		f, err := os.Create(dest)
+		if err != nil {
+			return nil, err
+		}
+
+		w := &tarExportWriter{
+			file:   f,
+			prefix: strings.TrimSuffix(filepath.Base(dest), ext) + "/",
+		}
+
+		var out io.Writer = f
+		if ext != ".tar" {
+			w.gzip = gzip.NewWriter(f)
+			out = w.gzip
+		}
+
+		w.tar = tar.NewWriter(out)
+		return w, nil
+	}
+
+	// Otherwise, the destination is a directory, which should
+	// not already contain anything
+	nonEmpty := false
+	if err := readDir(dest, func(_ os.FileInfo) bool {
+		nonEmpty = true
+		return false
+	}); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if nonEmpty {
+		return nil, fmt.Errorf("Directory %s already exists and is not empty", dest)
+	}
+
+	return &dirExportWriter{dir: dest}, os.MkdirAll(dest, 0777)
+}
+
+// Writes a tarball, with the files under a top-level directory named
+// after it.
+type tarExportWriter struct {
+	file   *os.File
+	gzip   *gzip.Writer
+	tar    *tar.Writer
+	prefix string
+}
+
+func (w *tarExportWriter) add(hdr *tar.Header, r io.Reader) error {
+	hdr.Name = w.prefix + hdr.Name
+	if err := w.tar.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	_, err := io.Copy(w.tar, r)
+	return err
+}
+
+func (w *tarExportWriter) close() error {
+	err := w.tar.Close()
+	if w.gzip != nil {
+		if err2 := w.gzip.Close(); err == nil {
+			err = err2
+		}
+	}
+
+	if err2 := w.file.Close(); err == nil {
+		err = err2
+	}
+
+	return err
+}
+
+type dirExportWriter struct {
+	dir string
+}
+
+func (w *dirExportWriter) add(hdr *tar.Header, r io.Reader) error {
+	dest := filepath.Join(w.dir, filepath.FromSlash(path.Clean(hdr.Name)))
+	if err := os.MkdirAll(filepath.Dir(dest), 0777); err != nil {
+		return err
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(dest, 0777)
+
+	case tar.TypeSymlink:
+		return os.Symlink(hdr.Linkname, dest)
+
+	default:
+		f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
+			os.FileMode(hdr.Mode)&os.ModePerm)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(f, r)
+		if err2 := f.Close(); err == nil {
+			err = err2
+		}
+
+		return err
+	}
+}
+
+func (w *dirExportWriter) close() error {
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestIsPackageDataDir(t *testing.T) {
+	goDirs := goPackageDirs([]string{
+		"root.go",
+		"p/p.go",
+		"p/sub/sub.go",
+		"p/testdata/gen/gen.go",
+		"p/tmpl/a.txt",
+		"p/tmpl/deep/b.txt",
+		"p/sub/data/c.txt",
+	})
+
+	tests := []struct {
+		dir, pkgdir string
+		data        bool
+	}{
+		{"p", "p", true},
+		{"p/tmpl", "p", true},
+		{"p/tmpl/deep", "p", true},
+		{"p/testdata", "p", true},
+		{"p/testdata/gen", "p", true},
+		{"p/sub", "p", false},
+		{"p/sub/data", "p", false},
+		{"p/sub/data", "p/sub", true},
+		{"q", "p", false},
+		{"pq", "p", false},
+		{"", "", true},
+		{"p/tmpl", "", false},
+		{"tmpl", "", true},
+	}
+
+	for _, test := range tests {
+		if res := isPackageDataDir(test.dir, test.pkgdir, goDirs); res != test.data {
+			t.Errorf("isPackageDataDir(%q, %q) = %t; expected %t",
+				test.dir, test.pkgdir, res, test.data)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Dependencies can have their own vendor directories, and the go
+// tool uses the packages in them in preference to those in the
+// top-level vendor directory.  That can lead to duplicate packages in
+// a build.  Hoisting lets the project own the versions of such
+// packages, by adding submodules for them at the top level.
+func (v *vendetta) hoistNestedPackages() error {
+	var pkgdirs []string
+	for pkgdir := range v.nestedPkgs {
+		pkgdirs = append(pkgdirs, pkgdir)
+	}
+
+	sort.Strings(pkgdirs)
+
+	top := &goPath{dir: "vendor"}
+	hoisted := make(map[string]bool)
+	for _, pkgdir := range pkgdirs {
+		pkg := v.nestedPkgs[pkgdir]
+		found, topdir, err := top.provides(pkg, v)
+		if err != nil {
+			return err
+		}
+
+		if found {
+			fmt.Printf("Package %s is vendored both in %s and at the top level\n", pkg, pkgdir)
+
+			// The project has chosen to own this package
+			if sm := v.pathInSubmodule(topdir); sm != nil {
+				sm.used = true
+			}
+
+			continue
+		}
+
+		// Find the nested submodule containing the package
+		sm := v.pathInSubmodule(pkgdir)
+		if sm == nil {
+			fmt.Printf("Package %s is only vendored in %s, which is not in a submodule\n", pkg, pkgdir)
+			continue
+		}
+
+		links, err := v.gitlinks(sm.dir)
+		if err != nil {
+			return err
+		}
+
+		var link *gitlink
+		for i := range links {
+			if isSubpath(pkgdir, filepath.Join(sm.dir, links[i].path)) {
+				link = &links[i]
+				break
+			}
+		}
+
+		if link == nil {
+			fmt.Printf("Package %s is only vendored in %s, which is not a submodule of %s, so it can't be hoisted\n", pkg, pkgdir, sm.dir)
+			continue
+		}
+
+		nestedDir := filepath.Join(sm.dir, link.path)
+		topDir := filepath.Join("vendor", nestedVendorPath(link.path))
+		if hoisted[topDir] {
+			continue
+		}
+
+		hoisted[topDir] = true
+		if v.readOnly {
+			fmt.Printf("Package %s is only vendored in %s\n", pkg, pkgdir)
+			continue
+		}
+
+		url, err := v.nestedSubmoduleURL(sm.dir, link.path)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Hoisting %s to %s\n", nestedDir, topDir)
+		if err := v.gitSubmoduleAdd(url, topDir); err != nil {
+			return err
+		}
+
+		if err := v.checkoutRevision(v.pathInSubmodule(topDir), link.commit); err != nil {
+			return err
+		}
+
+		// The hoisted packages might need dependencies that
+		// are not present at the top level.
+		if _, err := v.scanPackage(filepath.Join("vendor", packageToPath(pkg))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Is a package directory within a vendor directory inside a
+// dependency?
+func isNestedVendored(pkgdir string) bool {
+	if !isSubpath(pkgdir, "vendor") {
+		return false
+	}
+
+	for _, c := range strings.Split(pkgdir, string(os.PathSeparator))[1:] {
+		if c == "vendor" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Convert a path within a dependency's vendor directory to the
+// corresponding path in the top-level one.
+func nestedVendorPath(path string) string {
+	bits := strings.Split(path, "/")
+	for i := len(bits) - 1; i >= 0; i-- {
+		if bits[i] == "vendor" {
+			return packageToPath(strings.Join(bits[i+1:], "/"))
+		}
+	}
+
+	return packageToPath(path)
+}
+
+// A gitlink is an index entry for a submodule
+type gitlink struct {
+	path   string
+	commit string
+}
+
+// List the submodules of a repo, with the commits recorded for them.
+func (v *vendetta) gitlinks(dir string) ([]gitlink, error) {
+	files, err := v.popen("git", "-C", dir, "ls-files", "-s")
+	if err != nil {
+		return nil, err
+	}
+
+	defer files.close()
+
+	var links []gitlink
+	for files.Scan() {
+		// Each line is "<mode> <object> <stage>\t<path>"
+		line := files.Text()
+		tab := strings.IndexByte(line, '\t')
+		if tab < 0 {
+			return nil, fmt.Errorf("could not parse 'git ls-files' output")
+		}
+
+		fields := splitWS(line[:tab])
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("could not parse 'git ls-files' output")
+		}
+
+		if fields[0] == "160000" {
+			links = append(links, gitlink{
+				path:   line[tab+1:],
+				commit: fields[1],
+			})
+		}
+	}
+
+	if err := files.close(); err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+// Get the URL of a submodule of a submodule.
+func (v *vendetta) nestedSubmoduleURL(dir, path string) (string, error) {
+	entries, err := v.readConfigFile(filepath.Join(dir, ".gitmodules"))
+	if err != nil {
+		return "", err
+	}
+
+	// Find the submodule name for the path, then its url
+	name := ""
+	for _, e := range entries {
+		if e.section == "submodule" && e.name == "path" && e.value == path {
+			name = e.subsection
+		}
+	}
+
+	for _, e := range entries {
+		if e.section == "submodule" && e.subsection == name && e.name == "url" {
+			return e.value, nil
+		}
+	}
+
+	return "", fmt.Errorf("No URL found for submodule %s of %s", path, dir)
+}
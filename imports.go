@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Packages that have been renamed often carry an import comment
+// giving their canonical path, while code written before the rename
+// still imports them by the old path.  With -fiximports, such
+// imports in the root project are rewritten to the canonical path.
+
+// Get the import comment of the package in a directory, without
+// resolving its dependencies.
+func (v *vendetta) importComment(pkgdir string) string {
+	if pkg := v.dirPackages[pkgdir]; pkg != nil {
+		return pkg.ImportComment
+	}
+
+	// Errors are reported when the package is scanned
+	pkg, err := build.Default.ImportDir(v.realDir(pkgdir), build.ImportComment)
+	if err != nil {
+		return ""
+	}
+
+	return pkg.ImportComment
+}
+
+// Rewrite the imports of a package in the source files of a root
+// project directory to use a different path.
+func (v *vendetta) rewriteImports(dir, old, canonical string) error {
+	pkg := v.dirPackages[dir]
+	if pkg == nil {
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Rewriting imports of %s as %s in %s\n", old, canonical, v.realDir(dir))
+
+	var files []string
+	for _, list := range [][]string{pkg.GoFiles, pkg.CgoFiles, pkg.TestGoFiles, pkg.XTestGoFiles, pkg.IgnoredGoFiles} {
+		files = append(files, list...)
+	}
+
+	for _, name := range files {
+		if err := v.rewriteFileImports(filepath.Join(v.realDir(dir), name), old, canonical); err != nil {
+			return err
+		}
+	}
+
+	// Later passes over the root project's imports should see the
+	// canonical path
+	for _, list := range [][]string{pkg.Imports, pkg.TestImports, pkg.XTestImports} {
+		for i := range list {
+			if list[i] == old {
+				list[i] = canonical
+			}
+		}
+	}
+
+	return nil
+}
+
+func (v *vendetta) rewriteFileImports(file, old, canonical string) error {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, src, parser.ImportsOnly)
+	if err != nil {
+		return err
+	}
+
+	// Splice the new path into the source in place of each
+	// import path literal, leaving everything else untouched
+	var buf bytes.Buffer
+	pos := 0
+	for _, spec := range f.Imports {
+		if path, err := strconv.Unquote(spec.Path.Value); err != nil || path != old {
+			continue
+		}
+
+		start := fset.Position(spec.Path.Pos()).Offset
+		buf.Write(src[pos:start])
+		buf.WriteString(strconv.Quote(canonical))
+		pos = fset.Position(spec.Path.End()).Offset
+	}
+
+	if pos == 0 {
+		return nil
+	}
+
+	buf.Write(src[pos:])
+
+	fi, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+
+	v.journalUndo(func() error {
+		return ioutil.WriteFile(file, src, fi.Mode())
+	})
+
+	return ioutil.WriteFile(file, buf.Bytes(), fi.Mode())
+}
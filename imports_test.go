@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRewriteFileImports(t *testing.T) {
+	tests := []struct {
+		src, expected string
+	}{
+		// A single import
+		{"package a\n\nimport \"old/pkg\"\n",
+			"package a\n\nimport \"new/pkg\"\n"},
+
+		// Multiple specs, with named, dot and blank imports,
+		// and comments left untouched
+		{`package a
+
+import (
+	"fmt"
+	p "old/pkg" // was "old/pkg"
+	. "old/pkg/sub"
+	_ "old/pkg"
+	"old/pkgx"
+)
+
+var s = "old/pkg"
+`, `package a
+
+import (
+	"fmt"
+	p "new/pkg" // was "old/pkg"
+	. "old/pkg/sub"
+	_ "new/pkg"
+	"old/pkgx"
+)
+
+var s = "old/pkg"
+`},
+
+		// Several import declarations, and a raw string literal
+		{"package a\n\nimport \"old/pkg\"\nimport `old/pkg`\n",
+			"package a\n\nimport \"new/pkg\"\nimport \"new/pkg\"\n"},
+
+		// A cgo preamble mentioning the path is left alone
+		{`package a
+
+/*
+#include "old/pkg"
+*/
+import "C"
+import "old/pkg"
+`, `package a
+
+/*
+#include "old/pkg"
+*/
+import "C"
+import "new/pkg"
+`},
+
+		// No matching imports
+		{"package a\n\nimport \"other\"\n",
+			"package a\n\nimport \"other\"\n"},
+	}
+
+	dir, err := ioutil.TempDir("", "vendetta-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "a.go")
+	for _, test := range tests {
+		if err := ioutil.WriteFile(file, []byte(test.src), 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		var v vendetta
+		if err := v.rewriteFileImports(file, "old/pkg", "new/pkg"); err != nil {
+			t.Errorf("rewriting %q: %s", test.src, err)
+			continue
+		}
+
+		res, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(res) != test.expected {
+			t.Errorf("rewriting %q gave %q; expected %q", test.src, res, test.expected)
+		}
+
+		// The journal can restore the original
+		for _, undo := range v.journal.undos {
+			if err := undo(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if res, _ := ioutil.ReadFile(file); string(res) != test.src {
+			t.Errorf("undoing the rewrite of %q gave %q", test.src, res)
+		}
+	}
+}
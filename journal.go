@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// Vendetta changes the index, the working tree and .gitmodules as it
+// goes.  So that a run that fails partway through doesn't leave the
+// project in a half-changed state, each change is recorded in a
+// journal along with a way to undo it.  On failure, the changes are
+// undone in reverse order.
+type journal struct {
+	undos []func() error
+
+	// Whether anything has changed, including changes to
+	// .gitmodules that need no undo function
+	changed bool
+
+	// Actions that cannot be undone, and so are deferred until
+	// the run succeeds
+	deferred []func() error
+
+	// The state of .gitmodules before the run
+	gitmodules       []byte
+	gitmodulesExists bool
+	gitmodulesMode   string
+	gitmodulesSha    string
+
+	// Set by a SIGINT handler
+	interrupted int32
+
+	// Set while rolling back, when commands should still run
+	// despite an interruption
+	rollingBack bool
+}
+
+var errInterrupted = errors.New("Interrupted")
+
+func (v *vendetta) beginJournal() error {
+	data, err := ioutil.ReadFile(v.realDir(".gitmodules"))
+	switch {
+	case err == nil:
+		v.journal.gitmodules = data
+		v.journal.gitmodulesExists = true
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	v.journal.gitmodulesMode, v.journal.gitmodulesSha, err = v.indexEntry(".gitmodules")
+	return err
+}
+
+func (v *vendetta) journalUndo(undo func() error) {
+	v.journal.changed = true
+	v.journal.undos = append(v.journal.undos, undo)
+}
+
+func (v *vendetta) journalDefer(action func() error) {
+	v.journal.deferred = append(v.journal.deferred, action)
+}
+
+// Check that nothing is at the path where a submodule is about to
+// be added, so that whatever is there after the add can be removed
+// on rollback.  Returns a function to call once the add has been
+// attempted, which records the undo if the add created anything.
+func (v *vendetta) journalAddSubmodule(dir string) (func() error, error) {
+	noop := func() error { return nil }
+
+	_, sha, err := v.indexEntry(dir)
+	if err != nil || sha != "" {
+		return noop, err
+	}
+
+	occupied := false
+	if err := readDir(v.realDir(dir), func(_ os.FileInfo) bool {
+		occupied = true
+		return false
+	}); err != nil && !os.IsNotExist(err) {
+		return noop, err
+	}
+
+	if occupied {
+		return noop, nil
+	}
+
+	// The repo of a submodule previously at this path might have
+	// been left in .git/modules, and that should survive
+	name := filepath.ToSlash(dir)
+	_, modDir, err := v.submoduleGitDir(name)
+	if err != nil {
+		return noop, err
+	}
+
+	_, err = os.Stat(modDir)
+	if err != nil && !os.IsNotExist(err) {
+		return noop, err
+	}
+
+	purge := err != nil
+
+	return func() error {
+		_, sha, err := v.indexEntry(dir)
+		if err != nil {
+			return err
+		}
+
+		if _, err := os.Lstat(v.realDir(dir)); sha == "" && os.IsNotExist(err) {
+			return nil
+		}
+
+		v.journalUndo(func() error {
+			if err := v.git("update-index", "--force-remove", "--", dir); err != nil {
+				return err
+			}
+
+			if err := os.RemoveAll(v.realDir(dir)); err != nil {
+				return err
+			}
+
+			if purge {
+				if err := v.purgeSubmodule(&submodule{dir: dir, name: name}); err != nil {
+					return err
+				}
+			}
+
+			return v.removeEmptyDirsAbove(dir)
+		})
+
+		return nil
+	}, nil
+}
+
+// Write a file, recording how to restore what was there before.
+func (v *vendetta) writeFileJournalled(file string, data []byte) error {
+	old, err := ioutil.ReadFile(file)
+	existed := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	v.journalUndo(func() error {
+		if existed {
+			return ioutil.WriteFile(file, old, 0666)
+		}
+
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return nil
+	})
+
+	return ioutil.WriteFile(file, data, 0666)
+}
+
+// Record the state of a submodule before it is moved to a different
+// commit.
+func (v *vendetta) journalSubmoduleState(dir string) error {
+	head, err := v.gitOutput("-C", dir, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+
+	mode, sha, err := v.indexEntry(dir)
+	if err != nil {
+		return err
+	}
+
+	v.journalUndo(func() error {
+		if err := v.git("-C", dir, "checkout", "-q", "--detach", head); err != nil {
+			return err
+		}
+
+		if err := v.git("-C", dir, "submodule", "update", "--init",
+			"--recursive"); err != nil {
+			return err
+		}
+
+		return v.restoreIndexEntry(dir, mode, sha)
+	})
+
+	return nil
+}
+
+// Record that a submodule is about to be removed.
+func (v *vendetta) journalRemoveSubmodule(dir string) error {
+	mode, sha, err := v.indexEntry(dir)
+	if err != nil {
+		return err
+	}
+
+	v.journalUndo(func() error {
+		if err := v.restoreIndexEntry(dir, mode, sha); err != nil {
+			return err
+		}
+
+		// The submodule's repo is still in .git/modules, so
+		// this restores the working tree without fetching
+		return v.git("submodule", "update", "--init", "--recursive",
+			"--", dir)
+	})
+
+	return nil
+}
+
+// Undo the changes recorded in the journal.
+func (v *vendetta) rollback() error {
+	fmt.Fprintln(os.Stderr, "Rolling back changes")
+	v.journal.rollingBack = true
+
+	// The undo functions don't touch .gitmodules, but some of
+	// them need it, so restore it first.
+	if v.journal.gitmodulesExists {
+		if err := ioutil.WriteFile(v.realDir(".gitmodules"),
+			v.journal.gitmodules, 0666); err != nil {
+			return err
+		}
+	} else if err := os.Remove(v.realDir(".gitmodules")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := v.restoreIndexEntry(".gitmodules", v.journal.gitmodulesMode,
+		v.journal.gitmodulesSha); err != nil {
+		return err
+	}
+
+	for i := len(v.journal.undos) - 1; i >= 0; i-- {
+		if err := v.journal.undos[i](); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Perform the deferred actions after a successful run.
+func (v *vendetta) commitJournal() error {
+	for _, action := range v.journal.deferred {
+		if err := action(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (v *vendetta) isInterrupted() bool {
+	return atomic.LoadInt32(&v.journal.interrupted) != 0 &&
+		!v.journal.rollingBack
+}
+
+// Get the mode and object id of a path in the index.  They are empty
+// if the path is not in the index.
+func (v *vendetta) indexEntry(path string) (string, string, error) {
+	out, err := v.gitOutput("ls-files", "-s", "--", path)
+	if err != nil || out == "" {
+		return "", "", err
+	}
+
+	fields := splitWS(out)
+	if len(fields) < 2 {
+		return "", "", fmt.Errorf("could not parse 'git ls-files' output")
+	}
+
+	return fields[0], fields[1], nil
+}
+
+func (v *vendetta) restoreIndexEntry(path, mode, sha string) error {
+	if sha == "" {
+		return v.git("update-index", "--force-remove", "--", path)
+	}
+
+	return v.git("update-index", "--add", "--cacheinfo",
+		mode+","+sha+","+path)
+}
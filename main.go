@@ -9,10 +9,13 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 // TODO:
@@ -42,47 +45,235 @@ type config struct {
 	rootDir     string
 	projectName string
 	update      bool
+	updateTags  bool
+	updateDeps  []string
 	prune       bool
+	depth       int
+	filter      string
+	check       bool
+	keepPartial bool
+	hoist       bool
+	depTests    bool
+	prod        bool
+	force       bool
+	sparse      bool
+	strict      bool
+	fixImports  bool
+
+	// Set for commands that only report on the submodules, and
+	// so can run when some are missing from the working tree
+	reportOnly bool
 }
 
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [ <project directory> ]\n",
+		fmt.Fprintf(os.Stderr, "Usage: %s [ <options> ] [ <project directory> ]\n",
+			os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [ <options> ] -u|-t <dependency>...\n",
+			os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [ <options> ] add <import path>[@<revision>]\n",
+			os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [ <options> ] remove <import path>\n",
+			os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [ <options> ] conflicts|migrate|normalize|status\n",
+			os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [ <options> ] export <file.tar|file.tar.gz|directory>\n",
 			os.Args[0])
 		flag.PrintDefaults()
 	}
 
 	var cf config
 
+	flag.StringVar(&cf.rootDir, "C", "",
+		"the project directory (defaults to the current directory)")
 	flag.StringVar(&cf.projectName, "n", "",
 		"base package name for the project, e.g. github.com/user/proj")
 	flag.BoolVar(&cf.update, "u", false,
 		"update dependency submodules from their remote repos")
+	flag.BoolVar(&cf.updateTags, "t", false,
+		"update dependency submodules to the latest release tags compatible with their current versions")
 	flag.BoolVar(&cf.prune, "p", false,
 		"prune unused dependency submodules")
+	flag.BoolVar(&cf.check, "check", false,
+		"check that the dependency submodules are exactly those needed, without changing anything")
+	flag.BoolVar(&cf.hoist, "hoist", false,
+		"add top-level submodules for packages vendored within dependencies")
+	flag.BoolVar(&cf.depTests, "deptests", false,
+		"also vendor the packages imported by the tests of dependencies")
+	flag.BoolVar(&cf.prod, "prod", false,
+		"only vendor the packages needed to build the project, ignoring its tests")
+	flag.BoolVar(&cf.force, "f", false,
+		"update or remove submodules even if they contain local changes")
+	flag.BoolVar(&cf.sparse, "sparse", false,
+		"use sparse checkouts of dependency submodules, containing only the packages used")
+	flag.BoolVar(&cf.strict, "strict", false,
+		"fail when a package is imported by a path other than the one in its import comment")
+	flag.BoolVar(&cf.fixImports, "fiximports", false,
+		"rewrite imports in the project that use a path other than the one in the package's import comment")
+	flag.BoolVar(&cf.keepPartial, "k", false,
+		"if a run fails, keep the changes made so far rather than rolling them back")
+	flag.IntVar(&cf.depth, "depth", 0,
+		"create new dependency submodules as shallow clones of the given depth")
+	flag.StringVar(&cf.filter, "filter", "",
+		"create new dependency submodules as partial clones with the given filter, e.g. blob:none")
 
 	flag.Parse()
 
+	if cf.updateTags {
+		cf.update = true
+	}
+
+	if cf.check && (cf.update || cf.prune || cf.fixImports) {
+		fmt.Fprintln(os.Stderr, "The -check option cannot be combined with -u, -t, -p or -fiximports")
+		os.Exit(2)
+	}
+
+	args := flag.Args()
+	if len(args) > 0 {
+		if cmd := commands[args[0]]; cmd != nil {
+			cf.reportOnly = reportCommands[args[0]]
+			if err := run(&cf, cmd, args[1:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			return
+		}
+	}
+
+	if cf.update && len(args) > 0 && !(len(args) == 1 && cf.rootDir == "" && isGitRepo(args[0])) {
+		// With -u, the arguments select the dependencies to
+		// update.  But a single argument naming a git repo is
+		// the project directory, as it was before dependencies
+		// could be selected.
+		cf.updateDeps = args
+		args = nil
+	}
+
 	switch {
-	case flag.NArg() == 1:
-		cf.rootDir = flag.Arg(0)
-	case flag.NArg() > 1:
+	case len(args) == 1 && cf.rootDir == "":
+		cf.rootDir = args[0]
+	case len(args) > 0:
 		flag.Usage()
 		os.Exit(2)
 	}
 
-	if err := run(&cf); err != nil {
+	if err := run(&cf, (*vendetta).defaultCommand, nil); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
 
+// Is the directory the top of a git repo?  Submodules under vendor/
+// are git repos too, but they are dependencies rather than projects.
+func isGitRepo(dir string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(filepath.Clean(dir)), "/") {
+		if part == "vendor" {
+			return false
+		}
+	}
+
+	_, err := os.Stat(filepath.Join(dir, ".git"))
+	return err == nil
+}
+
+// Commands other than the default one are given by name after the
+// options, e.g. "vendetta add github.com/user/lib".
+type command func(v *vendetta, args []string) error
+
+var commands = map[string]command{
+	"add":       (*vendetta).addCommand,
+	"conflicts": (*vendetta).conflictsCommand,
+	"export":    (*vendetta).exportCommand,
+	"migrate":   (*vendetta).migrateCommand,
+	"normalize": (*vendetta).normalizeCommand,
+	"remove":    (*vendetta).removeCommand,
+	"status":    (*vendetta).statusCommand,
+}
+
+var reportCommands = map[string]bool{
+	"export": true,
+	"status": true,
+}
+
 type vendetta struct {
 	*config
 	goPath
+	projectConfig
 	goPaths     map[string]*goPath
 	dirPackages map[string]*build.Package
 	submodules  []submodule
+	rootPkgs    []rootPackage
+
+	// importedBy records, for each package directory found while
+	// resolving dependencies, the first import of it
+	importedBy map[string]importer
+
+	// All the imports of each package directory, including those
+	// of missing packages
+	importers map[string][]importer
+
+	// In read-only mode, missing packages are recorded in
+	// missing rather than obtained, and submodules are not
+	// updated.
+	readOnly bool
+	missing  []importer
+
+	// Packages found in vendor directories within dependencies,
+	// keyed by directory
+	nestedPkgs map[string]string
+
+	// The scope of the imports currently being resolved, and the
+	// scope in which each dependency package directory has been
+	// scanned
+	scope     scope
+	dirScopes map[string]scope
+
+	// In -check mode, the problems found
+	problems []string
+
+	journal journal
+}
+
+// Why a dependency is needed.  Narrower scopes have higher values.
+type scope int
+
+const (
+	// Needed to build the project
+	scopeProd scope = iota
+
+	// Needed by the tests of the project
+	scopeTest
+
+	// Needed only by the tests of dependencies (with -deptests)
+	scopeDepTest
+)
+
+func (s scope) String() string {
+	switch s {
+	case scopeProd:
+		return "prod"
+	case scopeTest:
+		return "test"
+	default:
+		return "dependency tests"
+	}
+}
+
+// When the scope options are used, messages about submodules are
+// labelled with the scope they are needed in.
+func (v *vendetta) scopeLabel(s scope) string {
+	if !v.prod && !v.depTests {
+		return ""
+	}
+
+	return " [" + s.String() + "]"
+}
+
+// An import of the package pkg from the package in dir
+type importer struct {
+	dir string
+	pkg string
 }
 
 // A goPath says where to search for packages (analogous to
@@ -107,23 +298,51 @@ type goPath struct {
 type submodule struct {
 	dir  string
 	used bool
+
+	// If the submodule is used, the widest scope it is used in
+	scope scope
+
+	// With -prod, whether the submodule is only used by the
+	// project's tests
+	testOnly bool
+
+	// Whether the submodule was selected for updating
+	selected bool
+
+	// The marker from 'git submodule status'
+	marker byte
+
+	// Settings from .gitmodules
+	name   string
+	url    string
+	branch string
 }
 
-func run(cf *config) error {
+func run(cf *config, cmd command, args []string) error {
 	v := vendetta{
 		config:      cf,
 		goPaths:     make(map[string]*goPath),
 		dirPackages: make(map[string]*build.Package),
+		importedBy:  make(map[string]importer),
+		importers:   make(map[string][]importer),
+		nestedPkgs:  make(map[string]string),
+		dirScopes:   make(map[string]scope),
 	}
 
 	v.goPaths[""] = &goPath{dir: "vendor", next: &v.goPath}
 	v.prefixes = make(map[string]struct{})
 
+	if err := v.loadProjectConfig(); err != nil {
+		return err
+	}
+
 	rootPkgs, err := v.scanRootProject()
 	if err != nil {
 		return err
 	}
 
+	v.rootPkgs = rootPkgs
+
 	if cf.projectName != "" {
 		v.prefixes[cf.projectName] = struct{}{}
 	} else {
@@ -150,11 +369,164 @@ func run(cf *config) error {
 		return err
 	}
 
-	if err := v.resolveRootProjectDeps(rootPkgs); err != nil {
+	if err := v.beginJournal(); err != nil {
+		return err
+	}
+
+	// On SIGINT, the git command being run will fail, or the
+	// next one will refuse to start, and then we roll back.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer func() {
+		signal.Stop(sigs)
+		close(sigs)
+	}()
+
+	go func() {
+		for range sigs {
+			atomic.StoreInt32(&v.journal.interrupted, 1)
+		}
+	}()
+
+	err = cmd(&v, args)
+	if err == nil && v.isInterrupted() {
+		err = errInterrupted
+	}
+
+	if err == nil {
+		return v.commitJournal()
+	}
+
+	if !v.journal.changed {
+		return err
+	}
+
+	if cf.keepPartial {
+		fmt.Fprintln(os.Stderr, "Keeping the changes made before the failure")
+		return err
+	}
+
+	if err2 := v.rollback(); err2 != nil {
+		return fmt.Errorf("%s\nRolling back failed: %s", err, err2)
+	}
+
+	return err
+}
+
+// Resolve the dependencies of the root project, adding submodules
+// for any that are missing.
+func (v *vendetta) defaultCommand(_ []string) error {
+	if err := v.selectSubmodules(); err != nil {
+		return err
+	}
+
+	v.readOnly = v.check
+	if err := v.resolveRootProjectDeps(v.rootPkgs); err != nil {
+		return err
+	}
+
+	if v.hoist {
+		if err := v.hoistNestedPackages(); err != nil {
+			return err
+		}
+	}
+
+	if v.prod {
+		if err := v.findTestOnlySubmodules(); err != nil {
+			return err
+		}
+	}
+
+	for _, imp := range v.missing {
+		v.problem("Package %s imported from %s is not vendored",
+			imp.pkg, v.realDir(imp.dir))
+	}
+
+	if err := v.checkDuplicateRepos(); err != nil {
+		return err
+	}
+
+	for _, sm := range v.submodules {
+		if sm.selected && !sm.used {
+			fmt.Fprintf(os.Stderr, "Not updating unused submodule %s\n", sm.dir)
+		}
+	}
+
+	if err := v.pruneSubmodules(); err != nil {
+		return err
+	}
+
+	// Submodules removed by pruning can't collide
+	if err := v.checkCaseCollisions(); err != nil {
 		return err
 	}
 
-	return v.pruneSubmodules()
+	if v.sparse && !v.readOnly {
+		if err := v.sparsifySubmodules(); err != nil {
+			return err
+		}
+	}
+
+	if v.prod || v.depTests {
+		v.listScopes()
+	}
+
+	if len(v.problems) > 0 {
+		return fmt.Errorf("Check failed with %d problem(s):\n\t%s",
+			len(v.problems), strings.Join(v.problems, "\n\t"))
+	}
+
+	return nil
+}
+
+// With -prod, find the submodules that are only needed for the tests
+// of the project, so that they can be reported and pruned.  This
+// resolves the test imports without changing anything.
+func (v *vendetta) findTestOnlySubmodules() error {
+	used := make([]bool, len(v.submodules))
+	for i := range v.submodules {
+		used[i] = v.submodules[i].used
+	}
+
+	readOnly, missing := v.readOnly, v.missing
+	v.readOnly = true
+	defer func() {
+		v.readOnly, v.missing, v.scope = readOnly, missing, scopeProd
+	}()
+
+	v.scope = scopeTest
+	for _, pkg := range v.rootPkgs {
+		if err := v.resolveDependencies(pkg.dir, pkg.TestImports); err != nil {
+			return err
+		}
+		if err := v.resolveDependencies(pkg.dir, pkg.XTestImports); err != nil {
+			return err
+		}
+	}
+
+	for i := range v.submodules {
+		sm := &v.submodules[i]
+		if sm.used && !used[i] {
+			sm.used = false
+			sm.testOnly = true
+		}
+	}
+
+	return nil
+}
+
+// List the used submodules with the scopes they are needed in.
+func (v *vendetta) listScopes() {
+	for _, sm := range v.submodules {
+		if sm.used && isSubpath(sm.dir, "vendor") {
+			fmt.Printf("%s%s\n", sm.dir, v.scopeLabel(sm.scope))
+		}
+	}
+}
+
+// Record a problem found in -check mode.
+func (v *vendetta) problem(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
 }
 
 // Attempt to infer the project name from GOPATH, by seeing if the
@@ -295,8 +667,17 @@ func (v *vendetta) inferredProjectName(proj string, source ...interface{}) {
 // Check for submodules that seem to be missing in the working tree.
 func (v *vendetta) checkSubmodules() error {
 	var err2 error
-	if err := v.querySubmodules(func(path string) bool {
+	if err := v.querySubmodules(func(path string, _ byte) bool {
 		err2 = v.checkSubmodule(path)
+		if err2 != nil && v.reportOnly {
+			// The missing submodules will be reported
+			err2 = nil
+		} else if err2 != nil && v.check {
+			// Report all missing submodules
+			v.problem("%s", err2)
+			err2 = nil
+		}
+
 		return err2 == nil
 	}, "--recursive"); err != nil {
 		return err
@@ -321,7 +702,11 @@ func (v *vendetta) checkSubmodule(dir string) error {
 	return nil
 }
 
-func (v *vendetta) querySubmodules(f func(string) bool, args ...string) error {
+// Call f with the path of each submodule, and the marker from the
+// start of its line in the 'git submodule status' output: '-' if it
+// is not initialized, '+' if the checked-out commit differs from the
+// index, 'U' if it has merge conflicts, or ' ' otherwise.
+func (v *vendetta) querySubmodules(f func(string, byte) bool, args ...string) error {
 	status, err := v.popen("git",
 		append([]string{"submodule", "status"}, args...)...)
 	if err != nil {
@@ -331,14 +716,15 @@ func (v *vendetta) querySubmodules(f func(string) bool, args ...string) error {
 	defer status.close()
 
 	for status.Scan() {
-		fields := splitWS(strings.TrimSpace(status.Text()))
+		line := status.Text()
+		fields := splitWS(strings.TrimSpace(line))
 		if len(fields) < 2 {
 			return fmt.Errorf("could not parse 'git submodule status' output")
 		}
 
 		path := fields[1]
 
-		if !f(path) {
+		if !f(path, line[0]) {
 			return nil
 		}
 	}
@@ -348,8 +734,10 @@ func (v *vendetta) querySubmodules(f func(string) bool, args ...string) error {
 
 func (v *vendetta) populateSubmodules() error {
 	var submodules []string
-	if err := v.querySubmodules(func(path string) bool {
+	markers := make(map[string]byte)
+	if err := v.querySubmodules(func(path string, marker byte) bool {
 		submodules = append(submodules, path)
+		markers[path] = marker
 		return true
 	}); err != nil {
 		return err
@@ -359,12 +747,82 @@ func (v *vendetta) populateSubmodules() error {
 
 	v.submodules = make([]submodule, 0, len(submodules))
 	for _, p := range submodules {
-		v.submodules = append(v.submodules, submodule{dir: p})
+		v.submodules = append(v.submodules,
+			submodule{dir: p, marker: markers[p]})
+	}
+
+	// Fill in the settings from .gitmodules.  The sections there
+	// are keyed by submodule name, which is usually but not
+	// necessarily the same as the path.
+	entries, err := v.readConfigFile(".gitmodules")
+	if err != nil {
+		return err
+	}
+
+	byName := make(map[string]map[string]string)
+	for _, e := range entries {
+		if e.section != "submodule" {
+			continue
+		}
+
+		settings := byName[e.subsection]
+		if settings == nil {
+			settings = make(map[string]string)
+			byName[e.subsection] = settings
+		}
+
+		settings[e.name] = e.value
+	}
+
+	for name, settings := range byName {
+		sm := v.pathInSubmodule(filepath.FromSlash(settings["path"]))
+		if sm == nil || sm.dir != filepath.FromSlash(settings["path"]) {
+			continue
+		}
+
+		sm.name = name
+		sm.url = settings["url"]
+		sm.branch = settings["branch"]
+	}
+
+	return nil
+}
+
+// Mark the submodules selected for updating by the import paths or
+// directories given with -u.
+func (v *vendetta) selectSubmodules() error {
+	for _, dep := range v.updateDeps {
+		sm, err := v.findDependency(dep)
+		if err != nil {
+			return err
+		}
+
+		sm.selected = true
 	}
 
 	return nil
 }
 
+// Find the submodule under vendor/ for a dependency given by import
+// path or directory.
+func (v *vendetta) findDependency(dep string) (*submodule, error) {
+	dir := filepath.Clean(dep)
+	if !isSubpath(dir, "vendor") {
+		dir = filepath.Join("vendor", packageToPath(dep))
+	}
+
+	sm := v.pathInSubmodule(dir)
+	if sm == nil || !isSubpath(sm.dir, "vendor") {
+		return nil, fmt.Errorf("'%s' is not a vendored dependency (use the -C option to specify the project directory)", dep)
+	}
+
+	return sm, nil
+}
+
+func (v *vendetta) shouldUpdate(sm *submodule) bool {
+	return v.update && !v.readOnly && (v.updateDeps == nil || sm.selected)
+}
+
 func (v *vendetta) pathInSubmodule(path string) *submodule {
 	i := sort.Search(len(v.submodules), func(i int) bool {
 		return v.submodules[i].dir >= path
@@ -378,14 +836,14 @@ func (v *vendetta) pathInSubmodule(path string) *submodule {
 	return nil
 }
 
-func (v *vendetta) addSubmodule(dir string) {
+func (v *vendetta) addSubmodule(sm submodule) {
 	i := sort.Search(len(v.submodules), func(i int) bool {
-		return v.submodules[i].dir >= dir
+		return v.submodules[i].dir >= sm.dir
 	})
 
 	submodules := make([]submodule, len(v.submodules)+1)
 	copy(submodules, v.submodules[:i])
-	submodules[i] = submodule{dir: dir, used: true}
+	submodules[i] = sm
 	copy(submodules[i+1:], v.submodules[i:])
 	v.submodules = submodules
 }
@@ -396,11 +854,130 @@ func isSubpath(path, dir string) bool {
 }
 
 func (v *vendetta) updateSubmodule(sm *submodule) error {
-	fmt.Fprintf(os.Stderr, "Updating submodule %s from remote\n", sm.dir)
-	if err := v.git("submodule", "update", "--remote", "--recursive", sm.dir); err != nil {
+	if !v.depConfigFor(sm.dir).hold {
+		if err := v.checkUnmodified(sm.dir, "updating"); err != nil {
+			return err
+		}
+	}
+
+	old, err := v.gitOutput("-C", sm.dir, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+
+	if err := v.journalSubmoduleState(sm.dir); err != nil {
+		return err
+	}
+
+	if err := v.updateSubmoduleHead(sm); err != nil {
+		return err
+	}
+
+	return v.showSubmoduleLog(sm.dir, old)
+}
+
+// Show the commits that an update brought into a submodule.
+func (v *vendetta) showSubmoduleLog(dir, old string) error {
+	head, err := v.gitOutput("-C", dir, "rev-parse", "HEAD")
+	if err != nil || head == old {
 		return err
 	}
 
+	if !v.gitSucceeds("-C", dir, "cat-file", "-e", old+"^{commit}") {
+		// This can happen with shallow submodules
+		fmt.Fprintf(os.Stderr, "Submodule %s moved from %s to %s\n",
+			dir, old[:7], head[:7])
+		return nil
+	}
+
+	if !v.gitSucceeds("-C", dir, "merge-base", "--is-ancestor", old, head) {
+		dropped, err := v.gitOutput("-C", dir, "rev-list", "--count",
+			head+".."+old)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Warning: Submodule %s moved from %s to %s, which is not a descendant (%s commit(s) dropped)\n",
+			dir, old[:7], head[:7], dropped)
+	}
+
+	commits, err := v.popen("git", "-C", dir, "log", "--oneline",
+		old+".."+head)
+	if err != nil {
+		return err
+	}
+
+	defer commits.close()
+
+	var lines []string
+	for commits.Scan() {
+		lines = append(lines, commits.Text())
+	}
+
+	if err := commits.close(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Submodule %s %s..%s, %d new commit(s):\n",
+		dir, old[:7], head[:7], len(lines))
+	for _, l := range lines {
+		fmt.Fprintf(os.Stderr, "\t%s\n", l)
+	}
+
+	return nil
+}
+
+func (v *vendetta) updateSubmoduleHead(sm *submodule) error {
+	dc := v.depConfigFor(sm.dir)
+	switch {
+	case dc.hold:
+		fmt.Fprintf(os.Stderr, "Not updating held submodule %s\n",
+			sm.dir)
+		return nil
+
+	case dc.tag != "":
+		return v.checkoutTag(sm.dir, dc.tag)
+
+	case dc.version != "":
+		return v.updateSubmoduleToTag(sm, dc.versionConstraint,
+			dc.version)
+
+	case v.updateTags:
+		return v.updateSubmoduleToCompatibleTag(sm)
+
+	case dc.branch != sm.branch && dc.branch != "":
+		// "git submodule update --remote" follows the branch
+		// given in .gitmodules
+		fmt.Fprintf(os.Stderr, "Tracking branch %s in submodule %s\n",
+			dc.branch, sm.dir)
+		if err := v.setSubmoduleConfig(sm, "branch", dc.branch); err != nil {
+			return err
+		}
+
+		sm.branch = dc.branch
+	}
+
+	fmt.Fprintf(os.Stderr, "Updating submodule %s from remote\n", sm.dir)
+	err := v.git("submodule", "update", "--remote", "--recursive", sm.dir)
+	if err != nil {
+		// The commit we are after might not be within the
+		// history of a shallow submodule, so deepen it and
+		// try again.
+		shallow, err2 := v.isShallow(sm.dir)
+		if err2 != nil || !shallow {
+			return err
+		}
+
+		if err := v.deepenSubmodule(sm.dir); err != nil {
+			return err
+		}
+
+		err = v.git("submodule", "update", "--remote", "--recursive", sm.dir)
+		if err != nil {
+			return err
+		}
+	}
+
 	// If we don't put the updated submodule into the index, a
 	// subsequent "git submodule update" will revert it, which can
 	// lead to surprises.
@@ -413,14 +990,24 @@ func (v *vendetta) pruneSubmodules() error {
 			continue
 		}
 
-		if v.prune {
-			fmt.Fprintf(os.Stderr, "Removing unused submodule %s\n",
-				sm.dir)
-			if err := v.git("rm", "-f", sm.dir); err != nil {
+		// With -prod, submodules only needed for tests are
+		// treated as unused, but reported as such
+		if sm.testOnly {
+			if err := v.pruneTestOnlySubmodule(&sm); err != nil {
 				return err
 			}
 
-			if err := v.removeEmptyDirsAbove(sm.dir); err != nil {
+			continue
+		}
+
+		if v.depConfigFor(sm.dir).hold {
+			fmt.Fprintf(os.Stderr, "Keeping unused submodule %s, as it is held\n", sm.dir)
+		} else if v.check {
+			v.problem("Submodule %s is unused", sm.dir)
+		} else if v.prune {
+			fmt.Fprintf(os.Stderr, "Removing unused submodule %s\n",
+				sm.dir)
+			if err := v.removeSubmodule(&sm); err != nil {
 				return err
 			}
 		} else {
@@ -431,18 +1018,153 @@ func (v *vendetta) pruneSubmodules() error {
 	return nil
 }
 
-func (v *vendetta) removeEmptyDirsAbove(dir string) error {
-	for {
-		dir = parentDir(dir)
-		if dir == "" {
-			return nil
-		}
+func (v *vendetta) pruneTestOnlySubmodule(sm *submodule) error {
+	label := v.scopeLabel(scopeTest)
+	if v.depConfigFor(sm.dir).hold {
+		fmt.Fprintf(os.Stderr, "Keeping submodule %s%s, only needed for tests, as it is held\n", sm.dir, label)
+	} else if v.check {
+		v.problem("Submodule %s%s is only needed for tests", sm.dir, label)
+	} else if v.prune {
+		fmt.Fprintf(os.Stderr, "Removing submodule %s%s, only needed for tests\n", sm.dir, label)
+		return v.removeSubmodule(sm)
+	} else {
+		fmt.Fprintf(os.Stderr, "Submodule %s%s is only needed for tests (use -p option to prune)\n", sm.dir, label)
+	}
 
-		empty := true
+	return nil
+}
+
+func (v *vendetta) removeSubmodule(sm *submodule) error {
+	if err := v.checkUnmodified(sm.dir, "removing"); err != nil {
+		return err
+	}
+
+	if err := v.journalRemoveSubmodule(sm.dir); err != nil {
+		return err
+	}
+
+	if err := v.git("rm", "-f", sm.dir); err != nil {
+		return err
+	}
+
+	return v.removeEmptyDirsAbove(sm.dir)
+}
+
+// Refuse to touch a submodule that has local work in it, unless the
+// -f option was given.
+func (v *vendetta) checkUnmodified(dir, action string) error {
+	if v.force {
+		return nil
+	}
+
+	changes, err := v.localChanges(dir)
+	if err != nil || len(changes) == 0 {
+		return err
+	}
+
+	return fmt.Errorf("Submodule %s has %s; not %s it (use -f to force)",
+		dir, strings.Join(changes, " and "), action)
+}
+
+// Describe the local work in a submodule that would be lost by
+// removing it or checking out a different commit.
+func (v *vendetta) localChanges(dir string) ([]string, error) {
+	status, err := v.popen("git", "-C", dir, "status", "--porcelain")
+	if err != nil {
+		return nil, err
+	}
+
+	defer status.close()
+
+	modified, untracked := false, false
+	for status.Scan() {
+		if strings.HasPrefix(status.Text(), "??") {
+			untracked = true
+		} else {
+			modified = true
+		}
+	}
+
+	if err := status.close(); err != nil {
+		return nil, err
+	}
+
+	var changes []string
+	if modified {
+		changes = append(changes, "uncommitted changes")
+	}
+
+	if untracked {
+		changes = append(changes, "untracked files")
+	}
+
+	// Commits reached by tags, such as release tags fetched into
+	// shallow submodules, or recorded in the project's index, are
+	// not local work
+	args := []string{"-C", dir, "rev-list", "--count", "HEAD",
+		"--branches", "--not", "--remotes", "--tags"}
+	_, indexCommit, err := v.indexEntry(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if indexCommit != "" && v.gitSucceeds("-C", dir, "cat-file", "-e", indexCommit+"^{commit}") {
+		args = append(args, indexCommit)
+	}
+
+	unpushed, err := v.gitOutput(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if unpushed != "0" {
+		changes = append(changes, unpushed+" local commit(s) not on any remote branch")
+	}
+
+	return changes, nil
+}
+
+// Remove a submodule added earlier in the run, along with its repo
+// under .git/modules, so that the add leaves no trace.
+func (v *vendetta) discardSubmodule(sm *submodule) error {
+	if err := v.git("rm", "-q", "-f", "--", sm.dir); err != nil {
+		return err
+	}
+
+	if err := v.purgeSubmodule(sm); err != nil {
+		return err
+	}
+
+	if err := v.removeEmptyDirsAbove(sm.dir); err != nil {
+		return err
+	}
+
+	for i := range v.submodules {
+		if v.submodules[i].dir == sm.dir {
+			v.submodules = append(v.submodules[:i:i], v.submodules[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+func (v *vendetta) removeEmptyDirsAbove(dir string) error {
+	for {
+		dir = parentDir(dir)
+		if dir == "" {
+			return nil
+		}
+
+		empty := true
 		if err := readDir(v.realDir(dir), func(_ os.FileInfo) bool {
 			empty = false
 			return false
 		}); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
 			return err
 		}
 
@@ -475,21 +1197,336 @@ func splitWS(s string) []string {
 }
 
 func (v *vendetta) gitSubmoduleAdd(url, dir string) error {
-	fmt.Fprintf(os.Stderr, "Adding %s at %s\n", url, dir)
-	err := v.git("submodule", "add", url, dir)
+	dc := v.depConfigFor(dir)
+
+	// A URL given explicitly in the config is used as is
+	if dc.url == "" {
+		url = v.canonicalURL(url)
+	}
+
+	fmt.Fprintf(os.Stderr, "Adding %s at %s%s\n", url, dir, v.scopeLabel(v.scope))
+
+	added, err := v.journalAddSubmodule(dir)
 	if err != nil {
 		return err
 	}
 
-	v.addSubmodule(dir)
+	args := []string{"submodule", "add"}
+	if dc.branch != "" {
+		args = append(args, "-b", dc.branch)
+	}
+
+	// "git submodule add" can't produce a partial clone, or a
+	// shallow clone of a branch other than the default.  In those
+	// cases we clone the repo ourselves, and then the submodule
+	// gets added from the existing repo.
+	cloned := v.filter != "" || v.depth > 0 && dc.branch != ""
+	if cloned {
+		cloneArgs := []string{"clone"}
+		if v.filter != "" {
+			cloneArgs = append(cloneArgs, "--filter="+v.filter)
+		}
+
+		if dc.branch != "" {
+			cloneArgs = append(cloneArgs, "-b", dc.branch)
+		}
+
+		if v.depth > 0 {
+			cloneArgs = append(cloneArgs,
+				"--depth", strconv.Itoa(v.depth))
+		}
+
+		err := v.git(append(cloneArgs, url, dir)...)
+		if err2 := added(); err == nil {
+			err = err2
+		}
+
+		if err != nil {
+			return err
+		}
+	} else if v.depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(v.depth))
+	}
+
+	err = v.git(append(args, url, dir)...)
+	if !cloned {
+		if err2 := added(); err == nil {
+			err = err2
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if cloned {
+		// Move the repo into .git/modules, as "git submodule
+		// add" would have done.
+		if err := v.git("submodule", "absorbgitdirs", dir); err != nil {
+			return err
+		}
+	}
+
+	v.addSubmodule(submodule{
+		dir:    dir,
+		used:   true,
+		scope:  v.scope,
+		name:   filepath.ToSlash(dir),
+		url:    url,
+		branch: dc.branch,
+	})
+	sm := v.pathInSubmodule(dir)
+
+	if v.depth > 0 {
+		// Record that the submodule should remain shallow
+		// when others clone the project.
+		if err := v.setSubmoduleConfig(sm, "shallow", "true"); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case dc.tag != "":
+		return v.checkoutTag(dir, dc.tag)
+	case dc.version != "":
+		tag, err := v.latestTag(dir, dc.versionConstraint, dc.version)
+		if err != nil {
+			return err
+		}
+
+		return v.checkoutTag(dir, tag)
+	}
+
 	return nil
 }
 
+// Set a submodule's setting in .gitmodules.
+func (v *vendetta) setSubmoduleConfig(sm *submodule, key, value string) error {
+	// .gitmodules gets restored on rollback
+	v.journal.changed = true
+	if err := v.git("config", "-f", ".gitmodules",
+		"submodule."+sm.name+"."+key, value); err != nil {
+		return err
+	}
+
+	return v.git("add", ".gitmodules")
+}
+
+// Check out the given tag in a submodule.
+func (v *vendetta) checkoutTag(dir, tag string) error {
+	head, commit, err := v.resolveTag(dir, tag)
+	if err != nil || head == commit {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Checking out tag %s in submodule %s\n",
+		tag, dir)
+	return v.checkoutCommit(dir, commit)
+}
+
+// Update a submodule to the latest tag satisfying a version
+// constraint.
+func (v *vendetta) updateSubmoduleToTag(sm *submodule, c semverConstraint, desc string) error {
+	tag, err := v.latestTag(sm.dir, c, desc)
+	if err != nil {
+		return err
+	}
+
+	head, commit, err := v.resolveTag(sm.dir, tag)
+	if err != nil {
+		return err
+	}
+
+	if head == commit {
+		fmt.Fprintf(os.Stderr, "Submodule %s is up to date at %s\n",
+			sm.dir, tag)
+		return nil
+	}
+
+	from, err := v.currentVersion(sm.dir)
+	if err != nil {
+		return err
+	}
+
+	if from == "" {
+		from = head[:7]
+	}
+
+	fmt.Fprintf(os.Stderr, "Updating submodule %s from %s to %s\n",
+		sm.dir, from, tag)
+	return v.checkoutCommit(sm.dir, commit)
+}
+
+// Update a submodule to the latest tag with a version compatible
+// with its current version.
+func (v *vendetta) updateSubmoduleToCompatibleTag(sm *submodule) error {
+	cur, err := v.currentVersion(sm.dir)
+	if err != nil {
+		return err
+	}
+
+	if cur == "" {
+		fmt.Fprintf(os.Stderr, "Not updating submodule %s, as it has no release tag\n", sm.dir)
+		return nil
+	}
+
+	sv, _ := parseSemver(cur)
+	c, err := parseSemverConstraint("^" + sv.String())
+	if err != nil {
+		return err
+	}
+
+	return v.updateSubmoduleToTag(sm, c, "compatible with "+cur)
+}
+
+// Find the version of a submodule's current commit, i.e. the highest
+// release tag it contains.  Returns "" if there is none.
+func (v *vendetta) currentVersion(dir string) (string, error) {
+	tags, err := v.popen("git", "-C", dir, "tag", "--merged", "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	defer tags.close()
+
+	var best string
+	var bestVer semver
+	for tags.Scan() {
+		sv, ok := parseSemver(tags.Text())
+		if ok && (best == "" || sv.compare(bestVer) > 0) {
+			best, bestVer = tags.Text(), sv
+		}
+	}
+
+	return best, tags.close()
+}
+
+// Get the commit at the HEAD of a submodule, and the commit of a
+// tag, fetching it if necessary.
+func (v *vendetta) resolveTag(dir, tag string) (head string, commit string, err error) {
+	ref := "refs/tags/" + tag + "^{commit}"
+	if !v.gitSucceeds("-C", dir, "rev-parse", "-q", "--verify", ref) {
+		if err := v.fetchTag(dir, tag); err != nil {
+			return "", "", err
+		}
+	}
+
+	head, err = v.gitOutput("-C", dir, "rev-parse", "HEAD")
+	if err != nil {
+		return "", "", err
+	}
+
+	commit, err = v.gitOutput("-C", dir, "rev-parse", ref)
+	return head, commit, err
+}
+
+func (v *vendetta) checkoutCommit(dir, commit string) error {
+	if err := v.git("-C", dir, "checkout", "-q", "--detach", commit); err != nil {
+		return err
+	}
+
+	if err := v.git("-C", dir, "submodule", "update", "--init",
+		"--recursive"); err != nil {
+		return err
+	}
+
+	// As in updateSubmodule, put the new commit into the index
+	return v.git("add", dir)
+}
+
+// Find the latest tag in a submodule's remote repo that satisfies a
+// version constraint.
+func (v *vendetta) latestTag(dir string, c semverConstraint, desc string) (string, error) {
+	tags, err := v.remoteTags(dir)
+	if err != nil {
+		return "", err
+	}
+
+	tag, found := latestMatchingTag(tags, c)
+	if !found {
+		return "", fmt.Errorf("No tags of submodule %s match version %s", dir, desc)
+	}
+
+	return tag, nil
+}
+
+// List the tags in a submodule's remote repo.
+func (v *vendetta) remoteTags(dir string) ([]string, error) {
+	refs, err := v.popen("git", "-C", dir, "ls-remote", "--tags",
+		"--refs", "origin")
+	if err != nil {
+		return nil, err
+	}
+
+	defer refs.close()
+
+	var tags []string
+	for refs.Scan() {
+		fields := splitWS(refs.Text())
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("could not parse 'git ls-remote' output")
+		}
+
+		tags = append(tags, strings.TrimPrefix(fields[1], "refs/tags/"))
+	}
+
+	if err := refs.close(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+func (v *vendetta) fetchTag(dir, tag string) error {
+	args := []string{"-C", dir, "fetch", "-q"}
+
+	// In a shallow repo, fetch just the tagged commit, rather
+	// than all the history leading up to it.
+	shallow, err := v.isShallow(dir)
+	if err != nil {
+		return err
+	}
+
+	if shallow {
+		args = append(args, "--depth", "1")
+	}
+
+	return v.git(append(args, "origin", "tag", tag)...)
+}
+
+func (v *vendetta) isShallow(dir string) (bool, error) {
+	out, err := v.gitOutput("-C", dir, "rev-parse",
+		"--is-shallow-repository")
+	if err != nil {
+		return false, err
+	}
+
+	return out == "true", nil
+}
+
+// Fetch the full history of a shallow submodule.
+func (v *vendetta) deepenSubmodule(dir string) error {
+	fmt.Fprintf(os.Stderr, "Deepening shallow submodule %s\n", dir)
+
+	// Shallow clones only fetch the remote's default branch, but
+	// the commit we want might be on another branch.
+	if err := v.git("-C", dir, "config", "remote.origin.fetch",
+		"+refs/heads/*:refs/remotes/origin/*"); err != nil {
+		return err
+	}
+
+	return v.git("-C", dir, "fetch", "--unshallow", "--tags", "origin")
+}
+
 func (v *vendetta) git(args ...string) error {
 	return v.system("git", args...)
 }
 
 func (v *vendetta) system(name string, args ...string) error {
+	if v.isInterrupted() {
+		return errInterrupted
+	}
+
 	cmd := exec.Command(name, args...)
 	cmd.Dir = v.rootDir
 	cmd.Stdout = os.Stdout
@@ -507,6 +1544,29 @@ func (v *vendetta) system(name string, args ...string) error {
 		name, strings.Join(args, " "), err)
 }
 
+// Run a git command and return its output, without any trailing
+// newline.
+func (v *vendetta) gitOutput(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = v.rootDir
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("Command failed: git %s (%s)",
+			strings.Join(args, " "), err)
+	}
+
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// Run a git command, reporting only whether it succeeded.
+func (v *vendetta) gitSucceeds(args ...string) bool {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = v.rootDir
+	return cmd.Run() == nil
+}
+
 type popenLines struct {
 	cmd    *exec.Cmd
 	stdout io.ReadCloser
@@ -618,10 +1678,19 @@ func (v *vendetta) scanRootProject() ([]rootPackage, error) {
 }
 
 func (v *vendetta) resolveRootProjectDeps(pkgs []rootPackage) error {
+	defer func() { v.scope = scopeProd }()
+
 	for _, pkg := range pkgs {
+		v.scope = scopeProd
 		if err := v.resolveDependencies(pkg.dir, pkg.Imports); err != nil {
 			return err
 		}
+
+		if v.prod {
+			continue
+		}
+
+		v.scope = scopeTest
 		if err := v.resolveDependencies(pkg.dir, pkg.TestImports); err != nil {
 			return err
 		}
@@ -644,17 +1713,42 @@ func mainOnly(pkgs []rootPackage) bool {
 }
 
 func (v *vendetta) scanPackage(dir string) (*build.Package, error) {
-	if pkg := v.dirPackages[dir]; pkg != nil {
-		return pkg, nil
+	pkg := v.dirPackages[dir]
+	if pkg != nil {
+		// Root packages have no scope, because their imports
+		// are resolved separately.  Other packages are
+		// scanned again when they are reached in a wider
+		// scope, so that the wider scope reaches their
+		// dependencies too.
+		s, scanned := v.dirScopes[dir]
+		if !scanned || s <= v.scope {
+			return pkg, nil
+		}
+	} else {
+		var err error
+		pkg, err = v.loadPackage(dir, false)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	pkg, err := v.loadPackage(dir, false)
-	if err != nil {
+	v.dirScopes[dir] = v.scope
+	if err := v.resolveDependencies(dir, pkg.Imports); err != nil {
 		return nil, err
 	}
 
-	if err = v.resolveDependencies(dir, pkg.Imports); err != nil {
-		return nil, err
+	if v.depTests && isSubpath(dir, "vendor") {
+		outer := v.scope
+		v.scope = scopeDepTest
+		err := v.resolveDependencies(dir, pkg.TestImports)
+		if err == nil {
+			err = v.resolveDependencies(dir, pkg.XTestImports)
+		}
+
+		v.scope = outer
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return pkg, nil
@@ -686,35 +1780,127 @@ func (v *vendetta) resolveDependencies(dir string, deps []string) error {
 }
 
 func (v *vendetta) resolveDependency(dir string, pkg string) error {
+	if v.isExcluded(pkg) {
+		return nil
+	}
+
 	found, pkgdir, err := v.searchGoPath(dir, pkg)
+	if err == nil && !found && !v.readOnly {
+		// The package might have been left out of the sparse
+		// checkout of a submodule
+		var widened bool
+		widened, err = v.widenSparseCheckout(pkg)
+		if widened && err == nil {
+			found, pkgdir, err = v.searchGoPath(dir, pkg)
+		}
+	}
+
+	// The submodule that this import made used, if any, and
+	// whether it was added for it
+	var newlyUsed *submodule
+	added := false
+
 	switch {
 	case err != nil:
 		return err
 	case found:
 		// Does the package fall within an existing submodule
 		// under vendor/ ?
-		if sm := v.pathInSubmodule(pkgdir); sm != nil && !sm.used {
-			sm.used = true
-			if v.update {
-				if err := v.updateSubmodule(sm); err != nil {
-					return err
+		if _, seen := v.importedBy[pkgdir]; !seen {
+			v.importedBy[pkgdir] = importer{dir, pkg}
+		}
+
+		if isNestedVendored(pkgdir) {
+			v.nestedPkgs[pkgdir] = pkg
+		}
+
+		if sm := v.pathInSubmodule(pkgdir); sm != nil {
+			if !sm.used {
+				newlyUsed = sm
+				sm.used = true
+				sm.scope = v.scope
+				if v.shouldUpdate(sm) {
+					if err := v.updateSubmodule(sm); err != nil {
+						return err
+					}
 				}
+			} else if v.scope < sm.scope {
+				sm.scope = v.scope
 			}
 		}
 
+	case v.readOnly:
+		if !isStandardPackage(pkg) {
+			v.missing = append(v.missing, importer{dir, pkg})
+			v.recordImporter(filepath.Join("vendor", packageToPath(pkg)),
+				importer{dir, pkg})
+		}
+
+		return nil
+
 	default:
 		pkgdir, err = v.obtainPackage(pkg)
 		if err != nil || pkgdir == "" {
 			return err
 		}
+
+		v.importedBy[pkgdir] = importer{dir, pkg}
+		newlyUsed = v.pathInSubmodule(pkgdir)
+		added = true
+	}
+
+	// Imports in the root project can be rewritten to use the
+	// canonical path, in which case the package at this path is
+	// not needed after all
+	if v.fixImports && !v.readOnly && !isSubpath(dir, "vendor") {
+		if canonical := v.importComment(pkgdir); canonical != "" && canonical != pkg {
+			if added && newlyUsed != nil {
+				// It was only added to find the import
+				// comment
+				fmt.Fprintf(os.Stderr, "Removing submodule %s, as %s is imported as %s instead\n",
+					newlyUsed.dir, pkg, canonical)
+				if err := v.discardSubmodule(newlyUsed); err != nil {
+					return err
+				}
+			} else if newlyUsed != nil {
+				newlyUsed.used = false
+			}
+
+			if v.importedBy[pkgdir] == (importer{dir, pkg}) {
+				delete(v.importedBy, pkgdir)
+			}
+
+			if err := v.rewriteImports(dir, pkg, canonical); err != nil {
+				return err
+			}
+
+			return v.resolveDependency(dir, canonical)
+		}
 	}
 
+	v.recordImporter(pkgdir, importer{dir, pkg})
 	pi, err := v.scanPackage(pkgdir)
 	if err != nil {
 		return err
 	}
 
 	if pi.ImportComment != "" && pkg != pi.ImportComment {
+		if v.check {
+			v.problem("Package with import comment %s referred to as %s (from directory %s)",
+				pi.ImportComment, pkg, v.realDir(dir))
+			return nil
+		}
+
+		if v.strict {
+			hint := ""
+			if !isSubpath(dir, "vendor") {
+				hint = "; use the -fiximports option to rewrite the imports"
+			}
+
+			return fmt.Errorf("Package with import comment %s referred to as %s (from directory %s)%s",
+				pi.ImportComment, pkg, v.realDir(dir), hint)
+		}
+
 		fmt.Printf("Warning: Package with import comment %s referred to as %s (from directory %s)\n",
 			pi.ImportComment, pkg, v.realDir(dir))
 	}
@@ -723,13 +1909,39 @@ func (v *vendetta) resolveDependency(dir string, pkg string) error {
 }
 
 func (v *vendetta) obtainPackage(pkg string) (string, error) {
-	bits := strings.Split(pkg, "/")
-
 	// Exclude golang standard packages
-	if !strings.Contains(bits[0], ".") {
+	if isStandardPackage(pkg) {
 		return "", nil
 	}
 
+	// A fork configured for the repo takes the place of the
+	// upstream
+	basePkg, dc := v.forkFor(pkg)
+	url := ""
+	if dc != nil {
+		url = dc.url
+		fmt.Fprintf(os.Stderr, "Using fork %s for %s\n", url, basePkg)
+	} else {
+		var err error
+		basePkg, url, err = discoverRepo(pkg)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	projDir := filepath.Join("vendor", packageToPath(basePkg))
+	if err := v.gitSubmoduleAdd(url, projDir); err != nil {
+		return "", err
+	}
+
+	return filepath.Join("vendor", packageToPath(pkg)), nil
+}
+
+// Find the git repo containing a package, returning the import path
+// of the root of the repo and its URL.
+func discoverRepo(pkg string) (string, string, error) {
+	bits := strings.Split(pkg, "/")
+
 	// Figure out how to obtain the package.  Packages on
 	// github.com are treated as a special case, because that is
 	// most of them.  Otherwise, we use the queryRepoRoot code
@@ -738,14 +1950,14 @@ func (v *vendetta) obtainPackage(pkg string) (string, error) {
 	var basePkg, url string
 	if bits[0] == "github.com" {
 		if len(bits) < 3 {
-			return "", fmt.Errorf("github.com package name %s seems to be truncated", pkg)
+			return "", "", fmt.Errorf("github.com package name %s seems to be truncated", pkg)
 		}
 
 		basePkg = strings.Join(bits[:3], "/")
 		url = "https://" + basePkg
 	} else if bits[0] == "bitbucket.org" {
 		if len(bits) < 3 {
-			return "", fmt.Errorf("bitbucket.org package name %s seems to be truncated", pkg)
+			return "", "", fmt.Errorf("bitbucket.org package name %s seems to be truncated", pkg)
 		}
 
 		basePkg = strings.Join(bits[:3], "/")
@@ -753,11 +1965,11 @@ func (v *vendetta) obtainPackage(pkg string) (string, error) {
 
 		// Probe to see if it is a git repo
 		if exec.Command("git", "ls-remote", url).Run() != nil {
-			return "", fmt.Errorf("Package %s does not seem to be git repo at %s; maybe it's an hg repo?", pkg, url)
+			return "", "", fmt.Errorf("Package %s does not seem to be git repo at %s; maybe it's an hg repo?", pkg, url)
 		}
 	} else if rr, err := queryRepoRoot(pkg, secure); err == nil {
 		if rr.vcs != "git" {
-			return "", fmt.Errorf("Package %s does not live in a git repo", pkg)
+			return "", "", fmt.Errorf("Package %s does not live in a git repo", pkg)
 		}
 
 		basePkg = rr.root
@@ -771,15 +1983,21 @@ func (v *vendetta) obtainPackage(pkg string) (string, error) {
 		url = fmt.Sprintf("https://%s.git", basePkg)
 		fmt.Printf("Warning: no go-import meta tags found for package '%s'. Guessing git repo URL '%s'\n", pkg, url)
 	} else {
-		return "", err
+		return "", "", err
 	}
 
-	projDir := filepath.Join("vendor", packageToPath(basePkg))
-	if err := v.gitSubmoduleAdd(url, projDir); err != nil {
-		return "", err
+	return basePkg, url, nil
+}
+
+// Standard packages are distinguished by the lack of a domain name
+// in the first component of the import path.
+func isStandardPackage(pkg string) bool {
+	first := pkg
+	if slash := strings.IndexByte(pkg, '/'); slash >= 0 {
+		first = pkg[:slash]
 	}
 
-	return filepath.Join("vendor", packageToPath(pkg)), nil
+	return !strings.Contains(first, ".")
 }
 
 // Search the gopath for the given dir to find an existing package
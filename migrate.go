@@ -0,0 +1,616 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// vendetta migrate
+//
+// Convert a vendor directory holding plain copies of dependencies
+// into submodules.  For each repo copied under vendor/, find the
+// commit in the upstream repo whose tree is closest to the copy,
+// replace the copy with a submodule at that commit, and save any
+// local modifications to the copy as a patch.
+func (v *vendetta) migrateCommand(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("Usage: vendetta migrate")
+	}
+
+	repos, err := v.copiedRepos()
+	if err != nil {
+		return err
+	}
+
+	if len(repos) == 0 {
+		fmt.Fprintln(os.Stderr, "No copied packages found under vendor/")
+		return nil
+	}
+
+	for _, r := range repos {
+		if err := v.migrateRepo(r.pkg, r.url); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type copiedRepo struct {
+	pkg string
+	url string
+}
+
+// Find the repos that have been copied into vendor/, from the go
+// files in the index that are not in submodules.
+func (v *vendetta) copiedRepos() ([]copiedRepo, error) {
+	files, err := v.popen("git", "ls-files", "-s", "--", "vendor")
+	if err != nil {
+		return nil, err
+	}
+
+	defer files.close()
+
+	dirSet := make(map[string]bool)
+	for files.Scan() {
+		line := files.Text()
+		tab := strings.IndexByte(line, '\t')
+		if tab < 0 {
+			return nil, fmt.Errorf("could not parse 'git ls-files' output")
+		}
+
+		path := filepath.FromSlash(line[tab+1:])
+		if strings.HasPrefix(line, "160000 ") || !strings.HasSuffix(path, ".go") {
+			continue
+		}
+
+		// Packages in vendor directories within the copies
+		// belong to the copies
+		if dir := parentDir(path); !isNestedVendored(dir) {
+			dirSet[dir] = true
+		}
+	}
+
+	if err := files.close(); err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for dir := range dirSet {
+		dirs = append(dirs, dir)
+	}
+
+	sort.Strings(dirs)
+
+	var repos []copiedRepo
+	known := make(map[string]bool)
+	for _, dir := range dirs {
+		pkg, ok := vendorImportPath(dir)
+		if !ok || inKnownRepo(pkg, known) {
+			continue
+		}
+
+		basePkg, url, err := discoverRepo(pkg)
+		if err != nil {
+			return nil, err
+		}
+
+		known[basePkg] = true
+		repos = append(repos, copiedRepo{basePkg, url})
+	}
+
+	return repos, nil
+}
+
+// Is the package within one of the known repos, given by the import
+// paths of their roots?  Sorting doesn't put the packages of a repo
+// together (foo/bar-baz comes between foo/bar and foo/bar/x), so each
+// ancestor has to be checked.
+func inKnownRepo(pkg string, known map[string]bool) bool {
+	for p := pkg; ; {
+		if known[p] {
+			return true
+		}
+
+		slash := strings.LastIndexByte(p, '/')
+		if slash < 0 {
+			return false
+		}
+
+		p = p[:slash]
+	}
+}
+
+func (v *vendetta) migrateRepo(pkg, url string) error {
+	dir := filepath.Join("vendor", packageToPath(pkg))
+
+	// The copy is restored from HEAD if we need to roll back, so
+	// it must not have any changes
+	changes, err := v.gitOutput("status", "--porcelain", "--", dir)
+	if err != nil {
+		return err
+	}
+
+	if changes != "" {
+		return fmt.Errorf("The copy of %s in %s has uncommitted changes; commit them before migrating", pkg, dir)
+	}
+
+	tmp, err := ioutil.TempDir("", "vendetta-migrate")
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(tmp)
+
+	fmt.Fprintf(os.Stderr, "Fetching %s to find the commit matching %s\n", url, dir)
+	if err := v.git("clone", "-q", "--no-checkout", url, tmp); err != nil {
+		return err
+	}
+
+	// Produce a tree object for the copy in the cloned repo, by
+	// adding it to the clone's index
+	copyDir, err := filepath.Abs(v.realDir(dir))
+	if err != nil {
+		return err
+	}
+
+	if err := v.git("-C", copyDir, "--git-dir="+filepath.Join(tmp, ".git"),
+		"--work-tree="+copyDir, "add", "-A", "."); err != nil {
+		return err
+	}
+
+	tree, err := v.gitOutput("-C", tmp, "write-tree")
+	if err != nil {
+		return err
+	}
+
+	match, err := v.closestCommit(tmp, tree)
+	if err != nil {
+		return err
+	}
+
+	if match.commit == "" {
+		return fmt.Errorf("No commits found in %s", url)
+	}
+
+	desc, err := v.describeCommit(tmp, match.commit)
+	if err != nil {
+		return err
+	}
+
+	var patch string
+	switch {
+	case match.changed == 0 && match.omitted == 0:
+		fmt.Fprintf(os.Stderr, "Copy of %s matches commit %s exactly\n",
+			pkg, desc)
+	case match.changed == 0:
+		fmt.Fprintf(os.Stderr, "Copy of %s matches commit %s, apart from %d file(s) omitted from the copy\n",
+			pkg, desc, match.omitted)
+	default:
+		patch = "vendetta-migrate-" + strings.Replace(pkg, "/", "_", -1) + ".patch"
+		diff, err := v.gitOutput("-C", tmp, "diff", "--binary",
+			"--diff-filter=AM", match.commit, tree)
+		if err != nil {
+			return err
+		}
+
+		if err := v.writeFileJournalled(v.realDir(patch), []byte(diff+"\n")); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Replacing the copy in %s with a submodule\n", dir)
+	v.journalUndo(func() error {
+		return v.git("checkout", "-q", "HEAD", "--", dir)
+	})
+
+	if err := v.git("rm", "-r", "-q", "--", dir); err != nil {
+		return err
+	}
+
+	// Files ignored by git would stop the submodule being added
+	leftover := ""
+	if err := readDir(v.realDir(dir), func(fi os.FileInfo) bool {
+		leftover = fi.Name()
+		return false
+	}); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if leftover != "" {
+		return fmt.Errorf("Directory %s still contains files not tracked by git (e.g. %s)", dir, leftover)
+	}
+
+	if err := v.gitSubmoduleAdd(url, dir); err != nil {
+		return err
+	}
+
+	if err := v.checkoutRevision(v.pathInSubmodule(dir), match.commit); err != nil {
+		return err
+	}
+
+	if patch != "" {
+		fmt.Printf("Warning: Copy of %s differs from its closest commit %s in %d file(s) (%d omitted from the copy); saved the differences as %s\n",
+			pkg, desc, match.changed, match.omitted, patch)
+	}
+
+	return nil
+}
+
+// The result of comparing a copy of a repo with a commit
+type commitMatch struct {
+	commit string
+
+	// The number of files added or modified in the copy, the
+	// number of lines changed in them, and the number of files
+	// omitted from the copy
+	changed, lines, omitted int
+}
+
+// Is m a closer match than o?  Copies often omit files such as
+// tests, so changes in the copy count for more than omitted files.
+func (m commitMatch) closerThan(o commitMatch) bool {
+	switch {
+	case m.changed != o.changed:
+		return m.changed < o.changed
+	case m.lines != o.lines:
+		return m.lines < o.lines
+	default:
+		return m.omitted < o.omitted
+	}
+}
+
+// Find the commit in a repo whose tree is closest to the given tree.
+func (v *vendetta) closestCommit(repo, tree string) (commitMatch, error) {
+	commits, err := v.popen("git", "-C", repo, "log", "--all",
+		"--format=%H %T")
+	if err != nil {
+		return commitMatch{}, err
+	}
+
+	defer commits.close()
+
+	// Pairs of commit and tree ids
+	var candidates [][2]string
+	for commits.Scan() {
+		fields := splitWS(commits.Text())
+		if len(fields) < 2 {
+			return commitMatch{}, fmt.Errorf("could not parse 'git log' output")
+		}
+
+		if fields[1] == tree {
+			return commitMatch{commit: fields[0]}, nil
+		}
+
+		candidates = append(candidates, [2]string{fields[0], fields[1]})
+	}
+
+	if err := commits.close(); err != nil {
+		return commitMatch{}, err
+	}
+
+	// No exact match.  Running 'git diff' against every commit
+	// would take too long for big repos, so first count the
+	// changed and omitted files for each commit by comparing
+	// trees directly, which is quick because most subtrees are
+	// shared.
+	trees, err := v.newTreeReader(repo, len(tree)/2)
+	if err != nil {
+		return commitMatch{}, err
+	}
+
+	defer trees.close()
+
+	var scored []commitMatch
+	for _, c := range candidates {
+		m, err := trees.compare(c[1], tree)
+		if err != nil {
+			return commitMatch{}, err
+		}
+
+		m.commit = c[0]
+		scored = append(scored, m)
+	}
+
+	if err := trees.close(); err != nil {
+		return commitMatch{}, err
+	}
+
+	// Only the commits with the fewest changed files can be the
+	// closest, and the line counts from 'git diff' decide between
+	// them.  Candidates are newest first, and the sort is stable.
+	sort.Stable(byChangedFiles(scored))
+
+	var best commitMatch
+	for i, s := range scored {
+		if i == maxDiffedCommits || s.changed > scored[0].changed {
+			break
+		}
+
+		m, err := v.compareWithCommit(repo, s.commit, tree)
+		if err != nil {
+			return commitMatch{}, err
+		}
+
+		if best.commit == "" || m.closerThan(best) {
+			best = m
+		}
+	}
+
+	return best, nil
+}
+
+type byChangedFiles []commitMatch
+
+func (ms byChangedFiles) Len() int      { return len(ms) }
+func (ms byChangedFiles) Swap(i, j int) { ms[i], ms[j] = ms[j], ms[i] }
+func (ms byChangedFiles) Less(i, j int) bool {
+	if ms[i].changed != ms[j].changed {
+		return ms[i].changed < ms[j].changed
+	}
+
+	return ms[i].omitted < ms[j].omitted
+}
+
+// The number of commits that are compared with a copy using 'git
+// diff', to count the lines changed.
+const maxDiffedCommits = 20
+
+func (v *vendetta) compareWithCommit(repo, commit, tree string) (commitMatch, error) {
+	// With both --raw and --numstat, the raw lines giving the
+	// status of each file come first, then the line counts.
+	diff, err := v.popen("git", "-C", repo, "diff", "--raw", "--numstat",
+		"--no-renames", commit, tree)
+	if err != nil {
+		return commitMatch{}, err
+	}
+
+	defer diff.close()
+
+	m := commitMatch{commit: commit}
+	omitted := make(map[string]bool)
+	for diff.Scan() {
+		line := diff.Text()
+		tab := strings.IndexByte(line, '\t')
+		if tab < 0 {
+			continue
+		}
+
+		if strings.HasPrefix(line, ":") {
+			fields := splitWS(line[:tab])
+			if fields[len(fields)-1] == "D" {
+				omitted[line[tab+1:]] = true
+				m.omitted++
+			} else {
+				m.changed++
+			}
+
+			continue
+		}
+
+		// "<added>\t<deleted>\t<path>", with "-" for binary
+		// files
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) < 3 || omitted[fields[2]] {
+			continue
+		}
+
+		for _, f := range fields[:2] {
+			if n, err := strconv.Atoi(f); err == nil {
+				m.lines += n
+			} else {
+				m.lines++
+			}
+		}
+	}
+
+	return m, diff.close()
+}
+
+// Reads tree objects from a repo through 'git cat-file --batch', so
+// that trees can be compared without starting a process for each.
+type treeReader struct {
+	cmd     *exec.Cmd
+	in      io.WriteCloser
+	out     *bufio.Reader
+	hashLen int
+
+	// The counts of files under trees, and of the files changed
+	// and omitted between pairs of trees
+	files    map[string]int
+	compared map[[2]string]commitMatch
+}
+
+type treeEntry struct {
+	mode, id string
+}
+
+func (v *vendetta) newTreeReader(repo string, hashLen int) (*treeReader, error) {
+	cmd := exec.Command("git", "-C", repo, "cat-file", "--batch")
+	cmd.Dir = v.rootDir
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &treeReader{
+		cmd:      cmd,
+		in:       in,
+		out:      bufio.NewReader(out),
+		hashLen:  hashLen,
+		files:    make(map[string]int),
+		compared: make(map[[2]string]commitMatch),
+	}, nil
+}
+
+func (r *treeReader) close() error {
+	if r.cmd == nil {
+		return nil
+	}
+
+	r.in.Close()
+	err := r.cmd.Wait()
+	r.cmd = nil
+	return err
+}
+
+// Read the entries of a tree, keyed by name.
+func (r *treeReader) read(id string) (map[string]treeEntry, error) {
+	if _, err := fmt.Fprintln(r.in, id); err != nil {
+		return nil, err
+	}
+
+	// The header is "<id> <type> <size>"
+	header, err := r.out.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	fields := splitWS(strings.TrimSpace(header))
+	if len(fields) < 3 || fields[1] != "tree" {
+		return nil, fmt.Errorf("could not read tree %s from 'git cat-file'", id)
+	}
+
+	size, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, size+1)
+	if _, err := io.ReadFull(r.out, data); err != nil {
+		return nil, err
+	}
+
+	// Each entry is "<mode> <name>\0" followed by the binary id
+	entries := make(map[string]treeEntry)
+	for data = data[:size]; len(data) > 0; {
+		nul := bytes.IndexByte(data, 0)
+		space := bytes.IndexByte(data, ' ')
+		if nul < 0 || space < 0 || space > nul || len(data) < nul+1+r.hashLen {
+			return nil, fmt.Errorf("could not parse tree %s", id)
+		}
+
+		entries[string(data[space+1:nul])] = treeEntry{
+			mode: string(data[:space]),
+			id:   hex.EncodeToString(data[nul+1 : nul+1+r.hashLen]),
+		}
+		data = data[nul+1+r.hashLen:]
+	}
+
+	return entries, nil
+}
+
+// Count the files added or modified in the tree b relative to a, and
+// those omitted from b.
+func (r *treeReader) compare(a, b string) (commitMatch, error) {
+	key := [2]string{a, b}
+	if m, ok := r.compared[key]; ok || a == b {
+		return m, nil
+	}
+
+	ae, err := r.read(a)
+	if err != nil {
+		return commitMatch{}, err
+	}
+
+	be, err := r.read(b)
+	if err != nil {
+		return commitMatch{}, err
+	}
+
+	var m commitMatch
+	for name, x := range ae {
+		y, inB := be[name]
+		switch {
+		case inB && x == y:
+		case inB && x.mode == "40000" && y.mode == "40000":
+			sub, err := r.compare(x.id, y.id)
+			if err != nil {
+				return commitMatch{}, err
+			}
+
+			m.changed += sub.changed
+			m.omitted += sub.omitted
+		case inB && x.mode != "40000" && y.mode != "40000":
+			m.changed++
+		default:
+			n, err := r.countFiles(x)
+			if err != nil {
+				return commitMatch{}, err
+			}
+
+			m.omitted += n
+			if inB {
+				if n, err = r.countFiles(y); err != nil {
+					return commitMatch{}, err
+				}
+
+				m.changed += n
+			}
+		}
+	}
+
+	for name, y := range be {
+		if _, inA := ae[name]; !inA {
+			n, err := r.countFiles(y)
+			if err != nil {
+				return commitMatch{}, err
+			}
+
+			m.changed += n
+		}
+	}
+
+	r.compared[key] = m
+	return m, nil
+}
+
+// Count the files of an entry, which is a single file unless it is
+// a tree.
+func (r *treeReader) countFiles(e treeEntry) (int, error) {
+	if e.mode != "40000" {
+		return 1, nil
+	}
+
+	if n, ok := r.files[e.id]; ok {
+		return n, nil
+	}
+
+	entries, err := r.read(e.id)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, sub := range entries {
+		c, err := r.countFiles(sub)
+		if err != nil {
+			return 0, err
+		}
+
+		n += c
+	}
+
+	r.files[e.id] = n
+	return n, nil
+}
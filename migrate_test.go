@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestInKnownRepo(t *testing.T) {
+	known := map[string]bool{"github.com/foo/bar": true}
+	tests := []struct {
+		pkg   string
+		known bool
+	}{
+		{"github.com/foo/bar", true},
+		{"github.com/foo/bar/x", true},
+		{"github.com/foo/bar/x/y", true},
+		{"github.com/foo/bar-baz", false},
+		{"github.com/foo/barx", false},
+		{"github.com/foo", false},
+	}
+
+	for _, test := range tests {
+		if res := inKnownRepo(test.pkg, known); res != test.known {
+			t.Errorf("inKnownRepo(%q) = %t; expected %t", test.pkg, res, test.known)
+		}
+	}
+}
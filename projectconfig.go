@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Settings for vendetta can be kept in a .vendetta file in the
+// top-level directory of the project.  It uses the same syntax as
+// .gitmodules, e.g.
+//
+//	[vendetta]
+//		exclude = google.golang.org/appengine/...
+//
+//	[dependency "github.com/user/lib"]
+//		branch = stable
+const projectConfigFile = ".vendetta"
+
+type projectConfig struct {
+	deps map[string]*depConfig
+
+	// Patterns for import paths that should never be vendored
+	excludes []string
+
+	// The scheme for submodule URLs: "https" or "ssh"
+	urlScheme string
+}
+
+// depConfig holds the settings from a [dependency "<import path>"]
+// section, where the import path is that of the root of the
+// dependency's repo.
+type depConfig struct {
+	// The remote branch that the submodule should track
+	branch string
+
+	// A tag that the submodule should be pinned to
+	tag string
+
+	// A semver constraint on the tags that the submodule
+	// should be updated to, e.g. "^1.2"
+	version           string
+	versionConstraint semverConstraint
+
+	// Held submodules are never updated or pruned
+	hold bool
+
+	// The URL of a fork to use in place of the upstream repo
+	url string
+}
+
+var noDepConfig = &depConfig{}
+
+func (v *vendetta) loadProjectConfig() error {
+	v.projectConfig = projectConfig{deps: make(map[string]*depConfig)}
+
+	entries, err := v.readConfigFile(projectConfigFile)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		switch e.section {
+		case "vendetta":
+			if err := v.setProjectSetting(e.name, e.value); err != nil {
+				return fmt.Errorf("%s: %s", projectConfigFile, err)
+			}
+
+		case "dependency":
+			if e.subsection == "" {
+				return fmt.Errorf("%s: dependency section without an import path", projectConfigFile)
+			}
+
+			dc := v.deps[e.subsection]
+			if dc == nil {
+				dc = &depConfig{}
+				v.deps[e.subsection] = dc
+			}
+
+			if err := dc.set(e.name, e.value); err != nil {
+				return fmt.Errorf("%s: dependency %s: %s",
+					projectConfigFile, e.subsection, err)
+			}
+
+		default:
+			return fmt.Errorf("%s: unknown section '%s'",
+				projectConfigFile, e.section)
+		}
+	}
+
+	for pkg, dc := range v.deps {
+		n := 0
+		for _, s := range []string{dc.branch, dc.tag, dc.version} {
+			if s != "" {
+				n++
+			}
+		}
+
+		if n > 1 {
+			return fmt.Errorf("%s: dependency %s: only one of branch, tag and version can be given", projectConfigFile, pkg)
+		}
+	}
+
+	return nil
+}
+
+func (pc *projectConfig) setProjectSetting(name, value string) error {
+	switch name {
+	case "exclude":
+		// Check the pattern is valid
+		if _, err := path.Match(value, ""); err != nil || value == "" {
+			return fmt.Errorf("invalid exclude pattern '%s'", value)
+		}
+
+		pc.excludes = append(pc.excludes, value)
+	case "urlscheme":
+		if value != "https" && value != "ssh" {
+			return fmt.Errorf("urlScheme should be https or ssh, not '%s'", value)
+		}
+
+		pc.urlScheme = value
+	default:
+		return fmt.Errorf("unknown setting '%s'", name)
+	}
+
+	return nil
+}
+
+// Should an import path be left alone, rather than vendored?  The
+// exclude patterns are import paths, globs, or import paths followed
+// by "/..." to cover the packages under them.
+func (pc *projectConfig) isExcluded(pkg string) bool {
+	for _, pat := range pc.excludes {
+		if strings.HasSuffix(pat, "/...") {
+			prefix := pat[:len(pat)-len("/...")]
+			if pkg == prefix || strings.HasPrefix(pkg, prefix+"/") {
+				return true
+			}
+
+			continue
+		}
+
+		if ok, _ := path.Match(pat, pkg); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (dc *depConfig) set(name, value string) error {
+	switch name {
+	case "branch":
+		dc.branch = value
+	case "tag":
+		dc.tag = value
+	case "version":
+		c, err := parseSemverConstraint(value)
+		if err != nil {
+			return err
+		}
+
+		dc.version = value
+		dc.versionConstraint = c
+	case "hold":
+		b, err := parseConfigBool(value)
+		if err != nil {
+			return err
+		}
+
+		dc.hold = b
+	case "url":
+		dc.url = value
+	default:
+		return fmt.Errorf("unknown setting '%s'", name)
+	}
+
+	return nil
+}
+
+// Get the settings for the dependency submodule in the given
+// directory.
+func (v *vendetta) depConfigFor(dir string) *depConfig {
+	if pkg, ok := vendorImportPath(dir); ok {
+		if dc := v.deps[pkg]; dc != nil {
+			return dc
+		}
+	}
+
+	return noDepConfig
+}
+
+// Find the dependency with a url setting whose repo contains the
+// given package, returning the import path of the repo root.  If
+// several contain it, the most specific wins.
+func (pc *projectConfig) forkFor(pkg string) (string, *depConfig) {
+	var best string
+	var bestDC *depConfig
+	for dep, dc := range pc.deps {
+		if dc.url != "" && (pkg == dep || strings.HasPrefix(pkg, dep+"/")) &&
+			len(dep) > len(best) {
+			best, bestDC = dep, dc
+		}
+	}
+
+	return best, bestDC
+}
+
+// Get the import path corresponding to a directory under the
+// top-level vendor directory.
+func vendorImportPath(dir string) (string, bool) {
+	if dir == "vendor" || !isSubpath(dir, "vendor") {
+		return "", false
+	}
+
+	return pathToPackage(dir[len("vendor")+1:]), true
+}
+
+// Parse a boolean setting, as git does.  A setting given without a
+// value is true.
+func parseConfigBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "", "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0":
+		return false, nil
+	}
+
+	return false, fmt.Errorf("invalid boolean value '%s'", value)
+}
+
+type configEntry struct {
+	section, subsection, name, value string
+}
+
+// Read a file in git config syntax.  A missing file is treated as
+// empty.
+func (v *vendetta) readConfigFile(file string) ([]configEntry, error) {
+	if _, err := os.Stat(v.realDir(file)); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	out, err := v.gitOutput("config", "-f", file, "--list", "-z")
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []configEntry
+	for _, item := range strings.Split(out, "\x00") {
+		if item == "" {
+			continue
+		}
+
+		// Each item is a key, then a newline, then the
+		// value.  The key has the form
+		// section[.subsection].name, where only the
+		// subsection can contain dots.
+		var e configEntry
+		key := item
+		if nl := strings.IndexByte(item, '\n'); nl >= 0 {
+			key, e.value = item[:nl], item[nl+1:]
+		}
+
+		first := strings.IndexByte(key, '.')
+		last := strings.LastIndexByte(key, '.')
+		if first < 0 {
+			return nil, fmt.Errorf("could not parse config key '%s' in %s", key, file)
+		}
+
+		e.section, e.name = key[:first], key[last+1:]
+		if first < last {
+			e.subsection = key[first+1 : last]
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// vendetta remove <import path>
+//
+// Remove the submodule for a dependency, provided the root project
+// no longer needs it.  Unlike pruning, this also cleans up the
+// submodule's repo under .git/modules and its entry in .git/config,
+// so that git won't be fussy if it is re-added later.
+func (v *vendetta) removeCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Usage: vendetta remove <import path>")
+	}
+
+	sm, err := v.findDependency(args[0])
+	if err != nil {
+		return err
+	}
+
+	if v.depConfigFor(sm.dir).hold {
+		return fmt.Errorf("Submodule %s is held (see %s)", sm.dir,
+			projectConfigFile)
+	}
+
+	v.readOnly = true
+	if err := v.resolveRootProjectDeps(v.rootPkgs); err != nil {
+		return err
+	}
+
+	if sm.used {
+		return fmt.Errorf("Submodule %s is still needed:\n%s",
+			sm.dir, v.why(sm))
+	}
+
+	fmt.Fprintf(os.Stderr, "Removing submodule %s\n", sm.dir)
+	if err := v.removeSubmodule(sm); err != nil {
+		return err
+	}
+
+	// Purging can't be undone, so it waits until we know there
+	// will be no need to roll back
+	removed := *sm
+	v.journalDefer(func() error {
+		return v.purgeSubmodule(&removed)
+	})
+
+	return nil
+}
+
+// Explain why a submodule is used, by giving the chain of imports
+// from the root project to a package in the submodule.
+func (v *vendetta) why(sm *submodule) string {
+	var pkgdirs []string
+	for pkgdir := range v.importedBy {
+		if isSubpath(pkgdir, sm.dir) {
+			pkgdirs = append(pkgdirs, pkgdir)
+		}
+	}
+
+	if len(pkgdirs) == 0 {
+		return ""
+	}
+
+	sort.Strings(pkgdirs)
+
+	// Walk back from the package to the root project.  Root
+	// packages can import each other, so stop at the first one,
+	// and guard against cycles.
+	var chain []string
+	dir := pkgdirs[0]
+	visited := make(map[string]bool)
+	for isSubpath(dir, "vendor") && !visited[dir] {
+		visited[dir] = true
+		imp, found := v.importedBy[dir]
+		if !found {
+			break
+		}
+
+		chain = append(chain, imp.pkg)
+		dir = imp.dir
+	}
+
+	root := "."
+	if dir != "" {
+		root += "/" + pathToPackage(dir)
+	}
+
+	chain = append(chain, root)
+
+	lines := make([]string, len(chain))
+	for i := range chain {
+		lines[i] = "\t" + chain[len(chain)-1-i]
+		if i < len(chain)-1 {
+			lines[i] += " imports"
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Remove what is left of a submodule in the .git directory after
+// "git rm".
+func (v *vendetta) purgeSubmodule(sm *submodule) error {
+	name := sm.name
+	if name == "" {
+		name = filepath.ToSlash(sm.dir)
+	}
+
+	modsDir, modDir, err := v.submoduleGitDir(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(modDir); err != nil {
+		return err
+	}
+
+	// Clean up the directories that held it, stopping at the
+	// first that isn't empty
+	for dir := filepath.Dir(modDir); isSubpath(dir, modsDir) && dir != modsDir; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+
+	if v.gitSucceeds("config", "--get", "submodule."+name+".url") {
+		return v.git("config", "--remove-section", "submodule."+name)
+	}
+
+	return nil
+}
+
+// Get the directory under .git that holds the repos of submodules,
+// and the repo of the named submodule within it.
+func (v *vendetta) submoduleGitDir(name string) (string, string, error) {
+	modsDir, err := v.gitOutput("rev-parse", "--git-path", "modules")
+	if err != nil {
+		return "", "", err
+	}
+
+	if !filepath.IsAbs(modsDir) {
+		modsDir = v.realDir(modsDir)
+	}
+
+	return modsDir, filepath.Join(modsDir, filepath.FromSlash(name)), nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWhy(t *testing.T) {
+	dep := filepath.Join("vendor", "example.com", "x")
+	v := vendetta{
+		importedBy: map[string]importer{
+			dep: {"b", "example.com/x"},
+
+			// An xtest of a imports b, and b imports a
+			"b": {"a", "example.com/proj/b"},
+			"a": {"b", "example.com/proj/a"},
+		},
+	}
+
+	res := v.why(&submodule{dir: dep})
+	expected := "\t./b imports\n\texample.com/x"
+	if res != expected {
+		t.Errorf("got %q; expected %q", res, expected)
+	}
+
+	// A cycle among vendored packages must not hang
+	other := filepath.Join("vendor", "example.com", "y")
+	v.importedBy = map[string]importer{
+		dep:   {other, "example.com/x"},
+		other: {dep, "example.com/y"},
+	}
+
+	if res := v.why(&submodule{dir: dep}); !strings.Contains(res, "example.com/x") {
+		t.Errorf("unexpected result %q", res)
+	}
+}
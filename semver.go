@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A semantic version, as found in the release tags of a repo
+// (e.g. "v1.2.3" or "1.2.3-rc1").
+type semver struct {
+	major, minor, patch int
+	pre                 string
+}
+
+var semverRE = regexp.MustCompile(`^v?(\d+)(?:\.(\d+)(?:\.(\d+))?)?(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+func parseSemver(s string) (semver, bool) {
+	m := semverRE.FindStringSubmatch(s)
+	if m == nil {
+		return semver{}, false
+	}
+
+	var sv semver
+	for i, p := range []*int{&sv.major, &sv.minor, &sv.patch} {
+		if m[i+1] == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			return semver{}, false
+		}
+
+		*p = n
+	}
+
+	sv.pre = m[4]
+	return sv, true
+}
+
+func (a semver) String() string {
+	s := fmt.Sprintf("%d.%d.%d", a.major, a.minor, a.patch)
+	if a.pre != "" {
+		s += "-" + a.pre
+	}
+	return s
+}
+
+func (a semver) compare(b semver) int {
+	switch {
+	case a.major != b.major:
+		return compareInts(a.major, b.major)
+	case a.minor != b.minor:
+		return compareInts(a.minor, b.minor)
+	case a.patch != b.patch:
+		return compareInts(a.patch, b.patch)
+	case a.pre == b.pre:
+		return 0
+	case a.pre == "":
+		// A release is later than its pre-releases
+		return 1
+	case b.pre == "":
+		return -1
+	default:
+		return comparePrerelease(a.pre, b.pre)
+	}
+}
+
+// Compare pre-release strings according to the semver spec:
+// dot-separated identifiers, with numeric identifiers compared
+// numerically.
+func comparePrerelease(a, b string) int {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.Atoi(as[i])
+		bn, berr := strconv.Atoi(bs[i])
+		switch {
+		case aerr == nil && berr == nil:
+			if an != bn {
+				return compareInts(an, bn)
+			}
+		case aerr == nil:
+			return -1
+		case berr == nil:
+			return 1
+		case as[i] != bs[i]:
+			return strings.Compare(as[i], bs[i])
+		}
+	}
+
+	return compareInts(len(as), len(bs))
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// A semverConstraint is a set of comparisons, all of which a
+// version must satisfy.
+type semverConstraint []semverComparison
+
+type semverComparison struct {
+	op string
+	v  semver
+}
+
+// Parse a constraint such as "^1.2", "~1.2.3", "1.x" or ">=1.2.0
+// <1.5.0".  Comparisons can be separated by spaces or commas.
+func parseSemverConstraint(s string) (semverConstraint, error) {
+	var c semverConstraint
+	for _, term := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == ','
+	}) {
+		comps, err := parseSemverTerm(term)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version constraint %q: %s", s, err)
+		}
+
+		c = append(c, comps...)
+	}
+
+	if len(c) == 0 {
+		return nil, fmt.Errorf("empty version constraint")
+	}
+
+	return c, nil
+}
+
+func parseSemverTerm(term string) ([]semverComparison, error) {
+	if term == "*" || term == "x" {
+		return []semverComparison{{">=", semver{}}}, nil
+	}
+
+	op := ""
+	for _, o := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(term, o) {
+			op = o
+			term = term[len(o):]
+			break
+		}
+	}
+
+	// Count the version components given, treating a trailing
+	// wildcard component as absent.
+	parts := strings.Split(strings.TrimPrefix(term, "v"), ".")
+	for len(parts) > 1 && isWildcard(parts[len(parts)-1]) {
+		parts = parts[:len(parts)-1]
+	}
+
+	sv, ok := parseSemver(strings.Join(parts, "."))
+	if !ok {
+		return nil, fmt.Errorf("%q is not a version", term)
+	}
+
+	ge := semverComparison{">=", sv}
+	switch {
+	case op == "^":
+		// Changes that do not modify the left-most non-zero
+		// component
+		switch {
+		case sv.major > 0 || len(parts) < 2:
+			return []semverComparison{ge, {"<", semver{major: sv.major + 1}}}, nil
+		case sv.minor > 0 || len(parts) < 3:
+			return []semverComparison{ge, {"<", semver{minor: sv.minor + 1}}}, nil
+		default:
+			return []semverComparison{ge, {"<", semver{patch: sv.patch + 1}}}, nil
+		}
+
+	case op == "~" || (op == "" || op == "=") && len(parts) < 3:
+		// Patch-level changes if a minor version is given,
+		// otherwise minor-level changes.
+		if len(parts) < 2 {
+			return []semverComparison{ge, {"<", semver{major: sv.major + 1}}}, nil
+		}
+		return []semverComparison{ge, {"<", semver{major: sv.major, minor: sv.minor + 1}}}, nil
+
+	case op == "":
+		op = "="
+	}
+
+	return []semverComparison{{op, sv}}, nil
+}
+
+func isWildcard(s string) bool {
+	return s == "x" || s == "X" || s == "*"
+}
+
+func (c semverConstraint) matches(sv semver) bool {
+	if sv.pre != "" && !c.mentionsPrerelease(sv) {
+		return false
+	}
+
+	for _, comp := range c {
+		cmp := sv.compare(comp.v)
+		var ok bool
+		switch comp.op {
+		case "=":
+			ok = cmp == 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		}
+
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Pre-releases only satisfy a constraint if it explicitly mentions
+// a pre-release of the same version.
+func (c semverConstraint) mentionsPrerelease(sv semver) bool {
+	for _, comp := range c {
+		if comp.v.pre != "" && comp.v.major == sv.major &&
+			comp.v.minor == sv.minor && comp.v.patch == sv.patch {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Find the tag with the highest version satisfying the constraint.
+func latestMatchingTag(tags []string, c semverConstraint) (string, bool) {
+	var best string
+	var bestVer semver
+	found := false
+	for _, tag := range tags {
+		sv, ok := parseSemver(tag)
+		if !ok || !c.matches(sv) {
+			continue
+		}
+
+		if !found || sv.compare(bestVer) > 0 {
+			best, bestVer, found = tag, sv, true
+		}
+	}
+
+	return best, found
+}
@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+func TestSemverConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		matching   []string
+		other      []string
+	}{
+		{"^1.2", []string{"v1.2.0", "1.9.9"}, []string{"v1.1.9", "v2.0.0", "v1.3.0-rc1"}},
+		{"^0.2.3", []string{"v0.2.3", "v0.2.9"}, []string{"v0.3.0", "v0.2.2"}},
+		{"^0.0.3", []string{"v0.0.3"}, []string{"v0.0.4", "v0.0.2"}},
+		{"^0.0.x", []string{"v0.0.0", "v0.0.9"}, []string{"v0.1.0"}},
+		{"~1.2.3", []string{"v1.2.3", "v1.2.10"}, []string{"v1.3.0", "v1.2.2"}},
+		{"~1", []string{"v1.0.0", "v1.5.0"}, []string{"v2.0.0"}},
+		{"1.x", []string{"v1.0.0", "v1.9.0"}, []string{"v0.9.0", "v2.0.0"}},
+		{"1.2", []string{"v1.2.0", "v1.2.5"}, []string{"v1.3.0"}},
+		{"=1.2.3", []string{"v1.2.3"}, []string{"v1.2.4"}},
+		{">=1.2.0 <1.5.0", []string{"v1.2.0", "v1.4.9"}, []string{"v1.5.0", "v1.1.0"}},
+		{">=1.2.0, <1.5.0", []string{"v1.3.0"}, []string{"v1.5.0"}},
+		{"*", []string{"v0.0.1", "v3.0.0"}, []string{"v3.0.0-beta"}},
+		{">=1.0.0-rc1", []string{"v1.0.0-rc1", "v1.0.0-rc2", "v1.0.0", "v1.2.0"}, []string{"v1.0.0-beta", "v1.1.0-rc1"}},
+		{"1.0.0-rc.2", []string{"v1.0.0-rc.2"}, []string{"v1.0.0-rc.10", "v1.0.0"}},
+	}
+
+	for _, test := range tests {
+		c, err := parseSemverConstraint(test.constraint)
+		if err != nil {
+			t.Errorf("parsing %q: %s", test.constraint, err)
+			continue
+		}
+
+		check := func(tag string, expected bool) {
+			sv, ok := parseSemver(tag)
+			if !ok {
+				t.Fatalf("could not parse %q", tag)
+			}
+
+			if c.matches(sv) != expected {
+				t.Errorf("%q matching %q: expected %t", test.constraint, tag, expected)
+			}
+		}
+
+		for _, tag := range test.matching {
+			check(tag, true)
+		}
+
+		for _, tag := range test.other {
+			check(tag, false)
+		}
+	}
+}
+
+func TestInvalidSemverConstraint(t *testing.T) {
+	for _, s := range []string{"", "^", "1.2.3.4", "foo", ">=1.x.2"} {
+		if _, err := parseSemverConstraint(s); err == nil {
+			t.Errorf("expected %q to be rejected", s)
+		}
+	}
+}
+
+func TestPrereleaseOrder(t *testing.T) {
+	// In increasing order, from the semver spec
+	versions := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta",
+		"1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0"}
+	for i := 1; i < len(versions); i++ {
+		a, _ := parseSemver(versions[i-1])
+		b, _ := parseSemver(versions[i])
+		if a.compare(b) >= 0 || b.compare(a) <= 0 {
+			t.Errorf("expected %s < %s", versions[i-1], versions[i])
+		}
+	}
+}
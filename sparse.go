@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Dependencies can be large repos of which the project only uses a
+// few packages.  With -sparse, each dependency submodule gets a
+// sparse checkout containing only the directories of the packages
+// used, along with the repo's license files.
+
+// Patterns for license files at the top of a repo.  Sparse checkout
+// patterns are case sensitive, so common variants are listed.
+var sparseLicensePatterns = []string{
+	"/LICENSE*", "/LICENCE*", "/License*", "/Licence*", "/license*",
+	"/licence*", "/COPYING*", "/Copying*", "/NOTICE*", "/Notice*",
+	"/PATENTS*", "/UNLICENSE*",
+}
+
+func (v *vendetta) sparsifySubmodules() error {
+	for i := range v.submodules {
+		sm := &v.submodules[i]
+		if !sm.used || !isSubpath(sm.dir, "vendor") || v.depConfigFor(sm.dir).hold {
+			continue
+		}
+
+		patterns, err := v.sparsePatterns(sm)
+		if err != nil {
+			return err
+		}
+
+		if err := v.setSparsePatterns(sm.dir, patterns); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Produce the sparse checkout patterns for the packages used in a
+// submodule.
+func (v *vendetta) sparsePatterns(sm *submodule) ([]string, error) {
+	links, err := v.gitlinks(sm.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Packages in nested submodules are covered by checking out
+	// the whole nested submodule
+	usedLinks := make(map[string]bool)
+	var rels []string
+	for dir := range v.dirPackages {
+		if dir != sm.dir && !isSubpath(dir, sm.dir) {
+			continue
+		}
+
+		rel := filepath.ToSlash(strings.TrimPrefix(dir[len(sm.dir):], string(os.PathSeparator)))
+		inLink := false
+		for _, link := range links {
+			if rel == link.path || strings.HasPrefix(rel, link.path+"/") {
+				usedLinks[link.path] = true
+				inLink = true
+				break
+			}
+		}
+
+		if !inLink {
+			rels = append(rels, rel)
+		}
+	}
+
+	files, err := v.treeFiles(sm.dir, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	// Include the subdirectories the packages need
+	for _, rel := range rels {
+		rels = append(rels, packageDataDirs(files, rel)...)
+	}
+
+	sort.Strings(rels)
+
+	patterns := append([]string(nil), sparseLicensePatterns...)
+	for i, rel := range rels {
+		if i == 0 || rel != rels[i-1] {
+			patterns = append(patterns, sparsePackagePatterns(rel)...)
+		}
+	}
+
+	for _, link := range links {
+		if usedLinks[link.path] {
+			patterns = append(patterns, "/"+link.path)
+		}
+	}
+
+	return patterns, nil
+}
+
+// Find the subdirectories of a package directory that are not
+// packages, and so hold files that the package might need.  files
+// are the slash-separated paths of the files in the repo.
+func packageDataDirs(files []string, pkgdir string) []string {
+	goDirs := goPackageDirs(files)
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, f := range files {
+		for d := slashParentDir(f); d != pkgdir && !seen[d]; d = slashParentDir(d) {
+			seen[d] = true
+			if isPackageDataDir(d, pkgdir, goDirs) {
+				dirs = append(dirs, d)
+			}
+
+			if d == "" {
+				break
+			}
+		}
+	}
+
+	sort.Strings(dirs)
+	return dirs
+}
+
+// The patterns for the files of the package in a directory, but not
+// its subdirectories.  Patterns for packages in subdirectories must
+// follow, to include them again.
+func sparsePackagePatterns(rel string) []string {
+	if rel == "" {
+		return []string{"/*", "!/*/"}
+	}
+
+	return []string{"/" + rel + "/*", "!/" + rel + "/*/"}
+}
+
+// If a package is missing because it was left out of the sparse
+// checkout of a submodule, widen the checkout to include it.
+func (v *vendetta) widenSparseCheckout(pkg string) (bool, error) {
+	pkgdir := filepath.Join("vendor", packageToPath(pkg))
+	sm := v.pathInSubmodule(pkgdir)
+	if sm == nil {
+		return false, nil
+	}
+
+	patterns, sparse, err := v.sparseCheckout(sm.dir)
+	if err != nil || !sparse {
+		return false, err
+	}
+
+	rel := filepath.ToSlash(strings.TrimPrefix(pkgdir[len(sm.dir):], string(os.PathSeparator)))
+	if !v.gitSucceeds("-C", sm.dir, "cat-file", "-e", "HEAD:"+rel) {
+		return false, nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Adding %s to the sparse checkout of submodule %s\n", pkg, sm.dir)
+
+	files, err := v.treeFiles(sm.dir, "HEAD")
+	if err != nil {
+		return false, err
+	}
+
+	var added []string
+	for _, d := range append([]string{rel}, packageDataDirs(files, rel)...) {
+		added = append(added, sparsePackagePatterns(d)...)
+	}
+
+	// The patterns for the package at the root of the repo
+	// exclude all subdirectories, so they must come before the
+	// patterns that include subdirectories again
+	if rel == "" {
+		patterns = append(added, patterns...)
+	} else {
+		patterns = append(patterns, added...)
+	}
+
+	return true, v.setSparsePatterns(sm.dir, patterns)
+}
+
+// Get the sparse checkout patterns of a submodule, and whether
+// sparse checkout is enabled.
+func (v *vendetta) sparseCheckout(dir string) ([]string, bool, error) {
+	// This fails if the setting is absent
+	enabled, err := v.gitOutput("-C", dir, "config", "--bool", "core.sparseCheckout")
+	if err != nil || enabled != "true" {
+		return nil, false, nil
+	}
+
+	file, err := v.sparseCheckoutFile(dir)
+	if err != nil {
+		return nil, false, err
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, false, err
+	}
+
+	var patterns []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			patterns = append(patterns, line)
+		}
+	}
+
+	return patterns, true, nil
+}
+
+func (v *vendetta) sparseCheckoutFile(dir string) (string, error) {
+	file, err := v.gitOutput("-C", dir, "rev-parse", "--git-path",
+		"info/sparse-checkout")
+	if err != nil {
+		return "", err
+	}
+
+	if !filepath.IsAbs(file) {
+		file = v.realDir(filepath.Join(dir, file))
+	}
+
+	return file, nil
+}
+
+func (v *vendetta) setSparsePatterns(dir string, patterns []string) error {
+	old, sparse, err := v.sparseCheckout(dir)
+	if err != nil {
+		return err
+	}
+
+	data := strings.Join(patterns, "\n") + "\n"
+	if sparse && strings.Join(old, "\n")+"\n" == data {
+		return nil
+	}
+
+	file, err := v.sparseCheckoutFile(dir)
+	if err != nil {
+		return err
+	}
+
+	oldData, err := ioutil.ReadFile(file)
+	oldExists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	v.journalUndo(func() error {
+		if sparse {
+			if !oldExists {
+				oldData = nil
+			}
+
+			if err := ioutil.WriteFile(file, oldData, 0666); err != nil {
+				return err
+			}
+
+			return v.git("-C", dir, "read-tree", "-mu", "HEAD")
+		}
+
+		// Turning sparse checkout off leaves the excluded files
+		// missing, so first check everything out with it on
+		if err := ioutil.WriteFile(file, []byte("/*\n"), 0666); err != nil {
+			return err
+		}
+
+		if err := v.git("-C", dir, "read-tree", "-mu", "HEAD"); err != nil {
+			return err
+		}
+
+		if err := v.git("-C", dir, "config", "core.sparseCheckout", "false"); err != nil {
+			return err
+		}
+
+		if oldExists {
+			return ioutil.WriteFile(file, oldData, 0666)
+		}
+
+		return os.Remove(file)
+	})
+
+	if !sparse {
+		fmt.Fprintf(os.Stderr, "Checking out only the used packages in submodule %s\n", dir)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(file, []byte(data), 0666); err != nil {
+		return err
+	}
+
+	if err := v.git("-C", dir, "config", "core.sparseCheckout", "true"); err != nil {
+		return err
+	}
+
+	if err := v.git("-C", dir, "read-tree", "-mu", "HEAD"); err != nil {
+		return err
+	}
+
+	// Nested submodules that are now included need checking out
+	return v.git("-C", dir, "submodule", "update", "--init", "--recursive")
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPackageDataDirs(t *testing.T) {
+	files := []string{
+		"LICENSE",
+		"root.go",
+		"assets/logo.png",
+		"p/p.go",
+		"p/tmpl/a.txt",
+		"p/tmpl/deep/b.txt",
+		"p/testdata/gen/gen.go",
+		"p/sub/sub.go",
+		"p/sub/data/c.txt",
+	}
+
+	tests := []struct {
+		pkgdir string
+		dirs   []string
+	}{
+		{"p", []string{"p/testdata", "p/testdata/gen", "p/tmpl", "p/tmpl/deep"}},
+		{"p/sub", []string{"p/sub/data"}},
+		{"", []string{"assets"}},
+	}
+
+	for _, test := range tests {
+		if dirs := packageDataDirs(files, test.pkgdir); !reflect.DeepEqual(dirs, test.dirs) {
+			t.Errorf("packageDataDirs(%q) = %q; expected %q", test.pkgdir, dirs, test.dirs)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// vendetta status
+//
+// Describe each submodule under vendor/ in terms that mean more than
+// the raw commit ids shown by 'git submodule status': its import
+// path, the tag of its commit, the branch it tracks, whether the
+// project uses it, and any local changes or unpushed commits that an
+// update might disturb.
+func (v *vendetta) statusCommand(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("Usage: vendetta status")
+	}
+
+	v.readOnly = true
+	if err := v.resolveRootProjectDeps(v.rootPkgs); err != nil {
+		return err
+	}
+
+	for i := range v.submodules {
+		sm := &v.submodules[i]
+		pkg, ok := vendorImportPath(sm.dir)
+		if !ok {
+			continue
+		}
+
+		lines, err := v.submoduleStatus(sm)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s (%s)\n", pkg, sm.dir)
+		for _, l := range lines {
+			fmt.Printf("\t%-9s %s\n", l[0]+":", l[1])
+		}
+	}
+
+	return nil
+}
+
+// Produce the status of a submodule, as pairs of labels and
+// descriptions.
+func (v *vendetta) submoduleStatus(sm *submodule) ([][2]string, error) {
+	var lines [][2]string
+	add := func(label, desc string) {
+		lines = append(lines, [2]string{label, desc})
+	}
+
+	_, indexCommit, err := v.indexEntry(sm.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if sm.marker == '-' {
+		add("commit", indexCommit+" (not checked out; run 'git submodule update --init --recursive')")
+		return lines, nil
+	}
+
+	head, err := v.gitOutput("-C", sm.dir, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	desc, err := v.describeCommit(sm.dir, head)
+	if err != nil {
+		return nil, err
+	}
+
+	add("commit", desc)
+	switch sm.marker {
+	case '+':
+		if d, err := v.describeCommit(sm.dir, indexCommit); err == nil {
+			indexCommit = d
+		}
+
+		add("index", indexCommit+", which differs from the checked-out commit")
+	case 'U':
+		add("index", "merge conflicts")
+	}
+
+	branch := sm.branch
+	if branch == "" {
+		branch = "(default)"
+	}
+
+	if v.depConfigFor(sm.dir).hold {
+		branch += ", held"
+	}
+
+	add("branch", branch)
+
+	if dc := v.depConfigFor(sm.dir); dc.url != "" {
+		add("fork", v.forkStatus(sm, dc, head))
+	}
+
+	local, err := v.gitOutput("-C", sm.dir, "symbolic-ref", "-q", "--short", "HEAD")
+	if err != nil {
+		add("head", "detached")
+	} else {
+		add("head", "on local branch "+local)
+	}
+
+	if sm.used {
+		add("used", "yes"+v.scopeLabel(sm.scope))
+	} else {
+		add("used", "no")
+	}
+
+	changes, err := v.localChanges(sm.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(changes) > 0 {
+		add("local", strings.Join(changes, ", "))
+	}
+
+	add("remote", v.remoteStatus(sm, head))
+	return lines, nil
+}
+
+// Describe how a submodule's commit relates to the head of the
+// remote branch it tracks.
+func (v *vendetta) remoteStatus(sm *submodule, head string) string {
+	ref := "HEAD"
+	if sm.branch != "" {
+		ref = "refs/heads/" + sm.branch
+	}
+
+	out, err := v.gitOutput("-C", sm.dir, "ls-remote", "origin", ref)
+	if err != nil {
+		return "could not query the remote repo"
+	}
+
+	fields := splitWS(out)
+	if len(fields) < 2 {
+		return "the tracked branch was not found in the remote repo"
+	}
+
+	remote := fields[0]
+	switch {
+	case remote == head:
+		return "up to date"
+	case !v.gitSucceeds("-C", sm.dir, "cat-file", "-e", remote+"^{commit}"):
+		return "behind (the remote has commits that have not been fetched)"
+	}
+
+	behind, err := v.gitOutput("-C", sm.dir, "rev-list", "--count",
+		head+".."+remote)
+	if err != nil {
+		return "could not compare with the remote"
+	}
+
+	ahead, err := v.gitOutput("-C", sm.dir, "rev-list", "--count",
+		remote+".."+head)
+	if err != nil {
+		return "could not compare with the remote"
+	}
+
+	switch {
+	case ahead == "0":
+		return "behind by " + behind + " commit(s)"
+	case behind == "0":
+		return "ahead by " + ahead + " commit(s)"
+	default:
+		return fmt.Sprintf("diverged (%s commit(s) behind, %s ahead)", behind, ahead)
+	}
+}
+
+// Describe how a submodule that uses a fork has diverged from the
+// upstream repo.
+func (v *vendetta) forkStatus(sm *submodule, dc *depConfig, head string) string {
+	desc := dc.url
+	if v.canonicalURL(sm.url) != v.canonicalURL(dc.url) {
+		desc += fmt.Sprintf(" (but the submodule's URL is %s)", sm.url)
+	}
+
+	pkg, _ := vendorImportPath(sm.dir)
+	_, upstream, err := discoverRepo(pkg)
+	if err != nil {
+		return desc + "; could not find the upstream repo"
+	}
+
+	// Fetch the upstream head into a ref of our own, so as not
+	// to disturb the fork's remote-tracking branches
+	ref := "refs/vendetta/upstream"
+	if err := v.git("-C", sm.dir, "fetch", "-q", upstream, "+HEAD:"+ref); err != nil {
+		return fmt.Sprintf("%s; could not fetch the upstream repo %s", desc, upstream)
+	}
+
+	counts, err := v.gitOutput("-C", sm.dir, "rev-list", "--left-right",
+		"--count", head+"..."+ref)
+	fields := splitWS(counts)
+	if err != nil || len(fields) < 2 {
+		return fmt.Sprintf("%s; could not compare with the upstream repo %s", desc, upstream)
+	}
+
+	return fmt.Sprintf("%s; %s commit(s) ahead of and %s behind the upstream repo %s",
+		desc, fields[0], fields[1], upstream)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// The same repo can be referred to by several URLs, e.g.
+// https://github.com/user/lib, https://github.com/user/lib.git and
+// git@github.com:user/lib.git.  Vendetta puts URLs for repos on the
+// well-known hosts into a canonical form, using https or ssh
+// according to the urlScheme setting.  Other servers might need the
+// exact URL, so their URLs are left alone.
+
+var (
+	schemeURLRE = regexp.MustCompile(`^(https?|git|ssh)://(?:([^@/]+)@)?([^/:]+)(?::(\d+))?/(.+)$`)
+	scpURLRE    = regexp.MustCompile(`^(?:([^@/]+)@)?([^/:]+):([^/].*)$`)
+)
+
+// The ports implied by the URL schemes
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"git":   "9418",
+	"ssh":   "22",
+}
+
+// Hosts where the URLs of a repo can be rewritten freely
+var canonicalHosts = map[string]bool{
+	"github.com":    true,
+	"gitlab.com":    true,
+	"bitbucket.org": true,
+}
+
+// The parts of a repo URL.  scp-like URLs have the scheme "ssh".
+type repoURL struct {
+	scheme, user, host, port, path string
+}
+
+// Split a URL into its host and repo path.  URLs of other kinds,
+// e.g. local paths, are not split.
+func splitRepoURL(url string) (string, string, bool) {
+	u, ok := parseRepoURL(url)
+	return u.host, u.path, ok
+}
+
+// Parse a URL.  The port is only set if it is not the default for
+// the scheme.
+func parseRepoURL(url string) (repoURL, bool) {
+	var u repoURL
+	if m := schemeURLRE.FindStringSubmatch(url); m != nil {
+		u = repoURL{scheme: m[1], user: m[2], host: m[3], path: m[5]}
+		if m[4] != defaultPorts[m[1]] {
+			u.port = m[4]
+		}
+	} else if m := scpURLRE.FindStringSubmatch(url); m != nil && !strings.Contains(url, "://") {
+		u = repoURL{scheme: "ssh", user: m[1], host: m[2], path: m[3]}
+	} else {
+		return repoURL{}, false
+	}
+
+	u.host = strings.ToLower(u.host)
+	u.path = strings.TrimSuffix(strings.TrimRight(u.path, "/"), ".git")
+	return u, true
+}
+
+func (v *vendetta) canonicalURL(url string) string {
+	u, ok := parseRepoURL(url)
+
+	// A non-default port can't be expressed in scp-like syntax,
+	// and might be the only way to reach the host with either
+	// scheme, so such URLs are left alone
+	if !ok || u.port != "" || !canonicalHosts[u.host] {
+		return url
+	}
+
+	// A user name given for one scheme means nothing for the
+	// other, but is kept for the same scheme, as credentials
+	// might depend on it
+	if v.urlScheme == "ssh" {
+		user := "git"
+		if u.scheme == "ssh" && u.user != "" {
+			user = u.user
+		}
+
+		return fmt.Sprintf("%s@%s:%s", user, u.host, u.path)
+	}
+
+	user := ""
+	if u.scheme == "https" && u.user != "" {
+		user = u.user + "@"
+	}
+
+	return fmt.Sprintf("https://%s%s/%s", user, u.host, u.path)
+}
+
+// vendetta normalize
+//
+// Rewrite the URLs of the submodules under vendor/ into canonical
+// form, and sync them into .git/config and the submodules' remotes.
+func (v *vendetta) normalizeCommand(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("Usage: vendetta normalize")
+	}
+
+	var changed []string
+	byURL := make(map[string]string)
+	for i := range v.submodules {
+		sm := &v.submodules[i]
+		if !isSubpath(sm.dir, "vendor") || sm.url == "" {
+			continue
+		}
+
+		// A URL given explicitly in the config is used as is
+		url := sm.url
+		if v.depConfigFor(sm.dir).url == "" {
+			url = v.canonicalURL(sm.url)
+		}
+
+		if other, dup := byURL[url]; dup {
+			fmt.Printf("Warning: Submodules %s and %s have the same URL %s\n",
+				other, sm.dir, url)
+		}
+
+		byURL[url] = sm.dir
+		if url == sm.url {
+			continue
+		}
+
+		fmt.Fprintf(os.Stderr, "Changing URL of submodule %s from %s to %s\n",
+			sm.dir, sm.url, url)
+		if err := v.setSubmoduleConfig(sm, "url", url); err != nil {
+			return err
+		}
+
+		sm.url = url
+		changed = append(changed, sm.dir)
+	}
+
+	if len(changed) == 0 {
+		fmt.Fprintln(os.Stderr, "All submodule URLs are already canonical")
+		return nil
+	}
+
+	// Syncing changes .git/config and the submodules' remotes,
+	// which the journal doesn't restore, so wait until the run
+	// has succeeded.
+	v.journalDefer(func() error {
+		return v.git(append([]string{"submodule", "sync", "-q", "--"},
+			changed...)...)
+	})
+
+	return nil
+}
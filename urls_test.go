@@ -0,0 +1,77 @@
+package main
+
+import "testing"
+
+func TestSplitRepoURL(t *testing.T) {
+	tests := []struct {
+		url, host, path string
+		ok              bool
+	}{
+		{"https://github.com/user/lib", "github.com", "user/lib", true},
+		{"https://GitHub.com/user/lib.git", "github.com", "user/lib", true},
+		{"https://github.com/user/lib/", "github.com", "user/lib", true},
+		{"http://example.com/a/b/c", "example.com", "a/b/c", true},
+		{"git://example.com/lib", "example.com", "lib", true},
+		{"ssh://git@example.com:2222/x/y.git", "example.com", "x/y", true},
+		{"https://example.com:8443/x/y", "example.com", "x/y", true},
+		{"git@github.com:user/lib.git", "github.com", "user/lib", true},
+		{"github.com:user/lib", "github.com", "user/lib", true},
+		{"/srv/git/lib.git", "", "", false},
+		{"../lib", "", "", false},
+		{"file:///srv/git/lib", "", "", false},
+	}
+
+	for _, test := range tests {
+		host, path, ok := splitRepoURL(test.url)
+		if host != test.host || path != test.path || ok != test.ok {
+			t.Errorf("splitRepoURL(%q) = %q, %q, %t; expected %q, %q, %t",
+				test.url, host, path, ok, test.host, test.path, test.ok)
+		}
+	}
+}
+
+func TestCanonicalURL(t *testing.T) {
+	tests := []struct {
+		url, https, ssh string
+	}{
+		{"https://github.com/user/lib", "https://github.com/user/lib", "git@github.com:user/lib"},
+		{"https://github.com/user/lib.git", "https://github.com/user/lib", "git@github.com:user/lib"},
+		{"git@github.com:user/lib.git", "https://github.com/user/lib", "git@github.com:user/lib"},
+		{"ssh://git@github.com/user/lib", "https://github.com/user/lib", "git@github.com:user/lib"},
+		{"ssh://git@github.com:22/user/lib", "https://github.com/user/lib", "git@github.com:user/lib"},
+		{"https://github.com:443/user/lib", "https://github.com/user/lib", "git@github.com:user/lib"},
+
+		// Non-default ports are kept
+		{"ssh://git@github.com:2222/x/y", "ssh://git@github.com:2222/x/y", "ssh://git@github.com:2222/x/y"},
+		{"https://github.com:8443/x/y", "https://github.com:8443/x/y", "https://github.com:8443/x/y"},
+
+		// User names are kept for the same scheme
+		{"https://me@github.com/user/lib.git", "https://me@github.com/user/lib", "git@github.com:user/lib"},
+		{"deploy@github.com:user/lib", "https://github.com/user/lib", "deploy@github.com:user/lib"},
+
+		// Self-hosted servers might need the exact URL
+		{"https://user@host.example/x/y.git", "https://user@host.example/x/y.git", "https://user@host.example/x/y.git"},
+		{"https://git.example.org/scm/repo.git", "https://git.example.org/scm/repo.git", "https://git.example.org/scm/repo.git"},
+		{"http://git.example.org/repo", "http://git.example.org/repo", "http://git.example.org/repo"},
+		{"git@git.example.org:team/repo.git", "git@git.example.org:team/repo.git", "git@git.example.org:team/repo.git"},
+
+		// Local paths are left alone
+		{"/srv/git/lib", "/srv/git/lib", "/srv/git/lib"},
+		{"file:///srv/git/lib", "file:///srv/git/lib", "file:///srv/git/lib"},
+	}
+
+	for _, test := range tests {
+		for _, scheme := range []string{"https", "ssh"} {
+			v := vendetta{projectConfig: projectConfig{urlScheme: scheme}}
+			expected := test.https
+			if scheme == "ssh" {
+				expected = test.ssh
+			}
+
+			if res := v.canonicalURL(test.url); res != expected {
+				t.Errorf("canonicalURL(%q) with %s = %q; expected %q",
+					test.url, scheme, res, expected)
+			}
+		}
+	}
+}